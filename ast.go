@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 )
 
@@ -11,6 +12,23 @@ type Node interface {
 	String() string
 }
 
+// Positioned is implemented by every node that was parsed from a token,
+// which is every node except Program
+type Positioned interface {
+	Position() Token
+}
+
+// nodeToken returns the token a node was parsed from, which gives its
+// source position
+func nodeToken(node Node) (Token, bool) {
+	p, ok := node.(Positioned)
+	if !ok {
+		return Token{}, false
+	}
+	tok := p.Position()
+	return tok, tok.Line > 0
+}
+
 // Statement represents a statement node
 type Statement interface {
 	Node
@@ -44,19 +62,35 @@ func (p *Program) String() string {
 }
 
 // DefStatement represents a variable definition: def x = 5
+// or a destructuring definition: def (a, b) = pair, def [x, y] = list
 type DefStatement struct {
-	Token    Token      // the DEF token
-	Name     *Identifier
-	TypeHint *TypeAnnotation // optional type hint
-	Value    Expression
+	Token       Token           // the DEF token
+	Name        *Identifier     // nil when destructuring
+	Targets     []*Identifier   // names bound by a destructuring definition
+	ListPattern bool            // targets were written as [a, b] rather than (a, b)
+	TypeHint    *TypeAnnotation // optional type hint
+	Value       Expression
 }
 
 func (ds *DefStatement) statementNode()       {}
 func (ds *DefStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DefStatement) Position() Token      { return ds.Token }
 func (ds *DefStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("def ")
-	out.WriteString(ds.Name.String())
+	if ds.Name != nil {
+		out.WriteString(ds.Name.String())
+	} else {
+		var names []string
+		for _, t := range ds.Targets {
+			names = append(names, t.String())
+		}
+		if ds.ListPattern {
+			out.WriteString("[" + strings.Join(names, ", ") + "]")
+		} else {
+			out.WriteString("(" + strings.Join(names, ", ") + ")")
+		}
+	}
 	if ds.TypeHint != nil {
 		out.WriteString(": ")
 		out.WriteString(ds.TypeHint.String())
@@ -73,9 +107,17 @@ type TypeAnnotation struct {
 	Token      Token // the type name token
 	Name       string
 	TypeParams []*TypeAnnotation // for generics like List[Integer]
+	Members    []*TypeAnnotation // for unions like Integer | String
 }
 
 func (ta *TypeAnnotation) String() string {
+	if len(ta.Members) > 0 {
+		var members []string
+		for _, m := range ta.Members {
+			members = append(members, m.String())
+		}
+		return strings.Join(members, " | ")
+	}
 	if len(ta.TypeParams) == 0 {
 		return ta.Name
 	}
@@ -94,6 +136,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Position() Token      { return rs.Token }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("return ")
@@ -111,6 +154,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Position() Token      { return es.Token }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -122,10 +166,12 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      Token // the { token
 	Statements []Statement
+	End        Token // the } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Position() Token      { return bs.Token }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("{ ")
@@ -144,16 +190,19 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Position() Token      { return i.Token }
 func (i *Identifier) String() string       { return i.Value }
 
 // IntegerLiteral represents an integer value
 type IntegerLiteral struct {
 	Token Token
 	Value int64
+	Big   *big.Int // set instead of Value when the literal doesn't fit in 64 bits
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Position() Token      { return il.Token }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 // FloatLiteral represents a floating-point value
@@ -164,6 +213,7 @@ type FloatLiteral struct {
 
 func (fl *FloatLiteral) expressionNode()      {}
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Position() Token      { return fl.Token }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
 
 // StringLiteral represents a string value
@@ -174,6 +224,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Position() Token      { return sl.Token }
 func (sl *StringLiteral) String() string       { return "\"" + sl.Value + "\"" }
 
 // BooleanLiteral represents true or false
@@ -184,6 +235,7 @@ type BooleanLiteral struct {
 
 func (bl *BooleanLiteral) expressionNode()      {}
 func (bl *BooleanLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BooleanLiteral) Position() Token      { return bl.Token }
 func (bl *BooleanLiteral) String() string       { return bl.Token.Literal }
 
 // PrefixExpression represents a prefix operation like -5 or not true
@@ -195,6 +247,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Position() Token      { return pe.Token }
 func (pe *PrefixExpression) String() string {
 	return "(" + pe.Operator + pe.Right.String() + ")"
 }
@@ -209,6 +262,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Position() Token      { return ie.Token }
 func (ie *InfixExpression) String() string {
 	return "(" + ie.Left.String() + " " + ie.Operator + " " + ie.Right.String() + ")"
 }
@@ -222,6 +276,7 @@ type AssignmentExpression struct {
 
 func (ae *AssignmentExpression) expressionNode()      {}
 func (ae *AssignmentExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignmentExpression) Position() Token      { return ae.Token }
 func (ae *AssignmentExpression) String() string {
 	return ae.Name.String() + " == " + ae.Value.String()
 }
@@ -236,6 +291,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Position() Token      { return ie.Token }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if ")
@@ -254,32 +310,83 @@ type WhileStatement struct {
 	Token     Token
 	Condition Expression
 	Body      *BlockStatement
+	Else      *BlockStatement // optional: runs when the loop ends without break
+	Label     *Identifier     // optional: outer: while ...
 }
 
 func (ws *WhileStatement) statementNode()       {}
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Position() Token      { return ws.Token }
 func (ws *WhileStatement) String() string {
 	var out bytes.Buffer
+	out.WriteString(labelPrefix(ws.Label))
 	out.WriteString("while ")
 	out.WriteString(ws.Condition.String())
 	out.WriteString(" ")
 	out.WriteString(ws.Body.String())
+	if ws.Else != nil {
+		out.WriteString(" else ")
+		out.WriteString(ws.Else.String())
+	}
+	return out.String()
+}
+
+// RepeatStatement represents a repeat { ... } until cond loop
+type RepeatStatement struct {
+	Token     Token
+	Body      *BlockStatement
+	Condition Expression
+	Label     *Identifier // optional: outer: repeat ...
+}
+
+func (rs *RepeatStatement) statementNode()       {}
+func (rs *RepeatStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *RepeatStatement) Position() Token      { return rs.Token }
+func (rs *RepeatStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(labelPrefix(rs.Label))
+	out.WriteString("repeat ")
+	out.WriteString(rs.Body.String())
+	out.WriteString(" until ")
+	out.WriteString(rs.Condition.String())
 	return out.String()
 }
 
+// GuardStatement represents guard cond else { ... }
+type GuardStatement struct {
+	Token     Token
+	Condition Expression
+	Else      *BlockStatement
+}
+
+func (gs *GuardStatement) statementNode()       {}
+func (gs *GuardStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GuardStatement) Position() Token      { return gs.Token }
+func (gs *GuardStatement) String() string {
+	return "guard " + gs.Condition.String() + " else " + gs.Else.String()
+}
+
 // ForStatement represents a for-in loop
 type ForStatement struct {
 	Token    Token
+	Index    *Identifier // optional: index (list) or key (map) in for i, x in ...
 	Variable *Identifier
 	Iterable Expression
 	Body     *BlockStatement
+	Label    *Identifier // optional: outer: for ...
 }
 
 func (fs *ForStatement) statementNode()       {}
 func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Position() Token      { return fs.Token }
 func (fs *ForStatement) String() string {
 	var out bytes.Buffer
+	out.WriteString(labelPrefix(fs.Label))
 	out.WriteString("for ")
+	if fs.Index != nil {
+		out.WriteString(fs.Index.String())
+		out.WriteString(", ")
+	}
 	out.WriteString(fs.Variable.String())
 	out.WriteString(" in ")
 	out.WriteString(fs.Iterable.String())
@@ -291,25 +398,48 @@ func (fs *ForStatement) String() string {
 // BreakStatement represents a break statement
 type BreakStatement struct {
 	Token Token
+	Label *Identifier // optional: break outer
 }
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BreakStatement) String() string       { return "break" }
+func (bs *BreakStatement) Position() Token      { return bs.Token }
+func (bs *BreakStatement) String() string {
+	if bs.Label != nil {
+		return "break " + bs.Label.String()
+	}
+	return "break"
+}
 
 // ContinueStatement represents a continue statement
 type ContinueStatement struct {
 	Token Token
+	Label *Identifier // optional: continue outer
 }
 
 func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
-func (cs *ContinueStatement) String() string       { return "continue" }
+func (cs *ContinueStatement) Position() Token      { return cs.Token }
+func (cs *ContinueStatement) String() string {
+	if cs.Label != nil {
+		return "continue " + cs.Label.String()
+	}
+	return "continue"
+}
+
+// labelPrefix renders an optional loop label as "name: "
+func labelPrefix(label *Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.String() + ": "
+}
 
 // FunctionStatement represents a function definition
 type FunctionStatement struct {
 	Token      Token
 	Name       *Identifier
+	TypeParams []*Identifier // generic type parameters: fun identity[T](...)
 	Parameters []*FunctionParameter
 	ReturnType *TypeAnnotation
 	Body       *BlockStatement
@@ -322,10 +452,18 @@ type FunctionParameter struct {
 
 func (fs *FunctionStatement) statementNode()       {}
 func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Position() Token      { return fs.Token }
 func (fs *FunctionStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("fun ")
 	out.WriteString(fs.Name.String())
+	if len(fs.TypeParams) > 0 {
+		var typeParams []string
+		for _, tp := range fs.TypeParams {
+			typeParams = append(typeParams, tp.String())
+		}
+		out.WriteString("[" + strings.Join(typeParams, ", ") + "]")
+	}
 	out.WriteString("(")
 	var params []string
 	for _, p := range fs.Parameters {
@@ -341,8 +479,10 @@ func (fs *FunctionStatement) String() string {
 		out.WriteString(" -> ")
 		out.WriteString(fs.ReturnType.String())
 	}
-	out.WriteString(" ")
-	out.WriteString(fs.Body.String())
+	if fs.Body != nil {
+		out.WriteString(" ")
+		out.WriteString(fs.Body.String())
+	}
 	return out.String()
 }
 
@@ -355,6 +495,7 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Position() Token      { return fl.Token }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("{ ")
@@ -378,6 +519,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Position() Token      { return ce.Token }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(ce.Function.String())
@@ -393,14 +535,19 @@ func (ce *CallExpression) String() string {
 
 // MemberExpression represents member access: obj.field
 type MemberExpression struct {
-	Token  Token
-	Object Expression
-	Member *Identifier
+	Token    Token
+	Object   Expression
+	Member   *Identifier
+	Optional bool // obj?.member, which gives None when obj is None
 }
 
 func (me *MemberExpression) expressionNode()      {}
 func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Position() Token      { return me.Token }
 func (me *MemberExpression) String() string {
+	if me.Optional {
+		return me.Object.String() + "?." + me.Member.String()
+	}
 	return me.Object.String() + "." + me.Member.String()
 }
 
@@ -413,6 +560,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Position() Token      { return ie.Token }
 func (ie *IndexExpression) String() string {
 	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
 }
@@ -425,6 +573,7 @@ type ListLiteral struct {
 
 func (ll *ListLiteral) expressionNode()      {}
 func (ll *ListLiteral) TokenLiteral() string { return ll.Token.Literal }
+func (ll *ListLiteral) Position() Token      { return ll.Token }
 func (ll *ListLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("[")
@@ -437,20 +586,44 @@ func (ll *ListLiteral) String() string {
 	return out.String()
 }
 
+// TupleLiteral represents a tuple: (1, "a")
+type TupleLiteral struct {
+	Token    Token // the ( token
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) Position() Token      { return tl.Token }
+func (tl *TupleLiteral) String() string {
+	var elements []string
+	for _, e := range tl.Elements {
+		elements = append(elements, e.String())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
 // MapLiteral represents a map: {"key": value}
 type MapLiteral struct {
 	Token Token
-	Pairs map[Expression]Expression
+	Pairs []*MapPair // in source order, so a repeated key keeps its last value
+}
+
+// MapPair is one key: value entry of a map literal
+type MapPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (ml *MapLiteral) expressionNode()      {}
 func (ml *MapLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MapLiteral) Position() Token      { return ml.Token }
 func (ml *MapLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("{")
 	var pairs []string
-	for k, v := range ml.Pairs {
-		pairs = append(pairs, k.String()+": "+v.String())
+	for _, pair := range ml.Pairs {
+		pairs = append(pairs, pair.Key.String()+": "+pair.Value.String())
 	}
 	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString("}")
@@ -471,6 +644,7 @@ type StructField struct {
 
 func (ss *StructStatement) statementNode()       {}
 func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) Position() Token      { return ss.Token }
 func (ss *StructStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("struct ")
@@ -489,20 +663,39 @@ func (ss *StructStatement) String() string {
 	return out.String()
 }
 
+// TypeAliasStatement represents a type alias: type Scores = Map[String, Integer]
+type TypeAliasStatement struct {
+	Token Token
+	Name  *Identifier
+	Value *TypeAnnotation
+}
+
+func (ts *TypeAliasStatement) statementNode()       {}
+func (ts *TypeAliasStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TypeAliasStatement) Position() Token      { return ts.Token }
+func (ts *TypeAliasStatement) String() string {
+	return "type " + ts.Name.String() + " = " + ts.Value.String()
+}
+
 // StructLiteral represents a struct instantiation: User { name: "Alice" }
 type StructLiteral struct {
 	Token      Token
 	StructName *Identifier
+	Spreads    []Expression // ...base entries, applied before Fields
 	Fields     map[string]Expression
 }
 
 func (sl *StructLiteral) expressionNode()      {}
 func (sl *StructLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StructLiteral) Position() Token      { return sl.Token }
 func (sl *StructLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString(sl.StructName.String())
 	out.WriteString(" { ")
 	var fields []string
+	for _, spread := range sl.Spreads {
+		fields = append(fields, "..."+spread.String())
+	}
 	for k, v := range sl.Fields {
 		fields = append(fields, k+": "+v.String())
 	}
@@ -520,6 +713,7 @@ type WithExpression struct {
 
 func (we *WithExpression) expressionNode()      {}
 func (we *WithExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WithExpression) Position() Token      { return we.Token }
 func (we *WithExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(we.Object.String())
@@ -542,6 +736,7 @@ type OptionExpression struct {
 
 func (oe *OptionExpression) expressionNode()      {}
 func (oe *OptionExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *OptionExpression) Position() Token      { return oe.Token }
 func (oe *OptionExpression) String() string {
 	if oe.IsSome {
 		return "Some(" + oe.Value.String() + ")"
@@ -558,6 +753,7 @@ type ResultExpression struct {
 
 func (re *ResultExpression) expressionNode()      {}
 func (re *ResultExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *ResultExpression) Position() Token      { return re.Token }
 func (re *ResultExpression) String() string {
 	if re.IsOk {
 		return "Ok(" + re.Value.String() + ")"
@@ -573,13 +769,15 @@ type MatchExpression struct {
 }
 
 type MatchCase struct {
-	Pattern    Expression
-	BindingVar *Identifier // the variable in Some(x) or Ok(x)
-	Body       *BlockStatement
+	Pattern      Expression
+	Alternatives []Expression // further literal patterns in `1, 2, 3 -> ...`
+	BindingVar   *Identifier  // the variable in Some(x) or Ok(x)
+	Body         *BlockStatement
 }
 
 func (me *MatchExpression) expressionNode()      {}
 func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Position() Token      { return me.Token }
 func (me *MatchExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("match ")
@@ -587,6 +785,10 @@ func (me *MatchExpression) String() string {
 	out.WriteString(" { ")
 	for _, c := range me.Cases {
 		out.WriteString(c.Pattern.String())
+		for _, alt := range c.Alternatives {
+			out.WriteString(", ")
+			out.WriteString(alt.String())
+		}
 		out.WriteString(" -> ")
 		out.WriteString(c.Body.String())
 		out.WriteString(" ")
@@ -604,6 +806,7 @@ type MutableExpression struct {
 
 func (me *MutableExpression) expressionNode()      {}
 func (me *MutableExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MutableExpression) Position() Token      { return me.Token }
 func (me *MutableExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("Mutable")
@@ -627,6 +830,7 @@ type ExtendStatement struct {
 
 func (es *ExtendStatement) statementNode()       {}
 func (es *ExtendStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExtendStatement) Position() Token      { return es.Token }
 func (es *ExtendStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("extend ")
@@ -640,6 +844,69 @@ func (es *ExtendStatement) String() string {
 	return out.String()
 }
 
+// EnumStatement declares a sum type:
+// enum Shape { Circle(Float), Rect(Float, Float) }
+type EnumStatement struct {
+	Token    Token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+
+// EnumVariant is one case of an enum and the types of its payload values
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*TypeAnnotation // empty for a variant without a payload
+}
+
+func (es *EnumStatement) statementNode()       {}
+func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EnumStatement) Position() Token      { return es.Token }
+func (es *EnumStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("enum ")
+	out.WriteString(es.Name.String())
+	out.WriteString(" { ")
+	var variants []string
+	for _, v := range es.Variants {
+		variant := v.Name.String()
+		if len(v.Fields) > 0 {
+			var fields []string
+			for _, f := range v.Fields {
+				fields = append(fields, f.String())
+			}
+			variant += "(" + strings.Join(fields, ", ") + ")"
+		}
+		variants = append(variants, variant)
+	}
+	out.WriteString(strings.Join(variants, ", "))
+	out.WriteString(" }")
+	return out.String()
+}
+
+// InterfaceStatement declares the methods a type must provide:
+// interface Shape { fun area() -> Float }. Its methods have no bodies.
+type InterfaceStatement struct {
+	Token   Token
+	Name    *Identifier
+	Methods []*FunctionStatement
+}
+
+func (is *InterfaceStatement) statementNode()       {}
+func (is *InterfaceStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *InterfaceStatement) Position() Token      { return is.Token }
+func (is *InterfaceStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("interface ")
+	out.WriteString(is.Name.String())
+	out.WriteString(" { ")
+	for _, m := range is.Methods {
+		out.WriteString(m.String())
+		out.WriteString(" ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
 // ImportStatement represents an import
 type ImportStatement struct {
 	Token Token
@@ -648,6 +915,7 @@ type ImportStatement struct {
 
 func (is *ImportStatement) statementNode()       {}
 func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Position() Token      { return is.Token }
 func (is *ImportStatement) String() string {
 	return "import " + strings.Join(is.Path, ".")
 }
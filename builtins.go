@@ -1,21 +1,103 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-// RegisterBuiltins registers all built-in functions
+// stdin is where readAll reads from; embedders can replace it
+var stdin io.Reader = os.Stdin
+
+// stdout and stderr are where the print builtins and log write
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// HostFunction is a Go function exposed to MoonShot code by an embedder
+type HostFunction struct {
+	Fn   func(args ...Value) Value
+	Type *FunctionType // signature for the type checker, nil means (Any) -> Any
+}
+
+// RegisterBuiltin adds a host function to env under name
+func RegisterBuiltin(env *Environment, name string, fn func(args ...Value) Value) {
+	env.Set(name, &BuiltinFunction{
+		Name: name,
+		Fn:   fn,
+	})
+}
+
+// RegisterBuiltins registers all built-in functions with default options
 func RegisterBuiltins(env *Environment) {
+	RegisterBuiltinsWithOptions(env, DefaultOptions())
+}
+
+// RegisterBuiltinsWithOptions registers all built-in functions, replacing
+// the ones disallowed by opts with stubs that return an error
+func RegisterBuiltinsWithOptions(env *Environment, opts Options) {
 	// I/O functions
 	env.Set("print", &BuiltinFunction{
 		Name: "print",
-		Fn:   builtinPrint,
+		Call: builtinPrint,
 	})
 
 	env.Set("println", &BuiltinFunction{
 		Name: "println",
-		Fn:   builtinPrintln,
+		Call: builtinPrintln,
+	})
+
+	env.Set("printParts", &BuiltinFunction{
+		Name: "printParts",
+		Call: builtinPrintParts,
+	})
+
+	env.Set("printlnParts", &BuiltinFunction{
+		Name: "printlnParts",
+		Call: builtinPrintlnParts,
+	})
+
+	env.Set("eprint", &BuiltinFunction{
+		Name: "eprint",
+		Call: builtinEprint,
+	})
+
+	env.Set("eprintln", &BuiltinFunction{
+		Name: "eprintln",
+		Call: builtinEprintln,
+	})
+
+	env.Set("assertEqual", &BuiltinFunction{
+		Name: "assertEqual",
+		Call: builtinAssertEqual,
+	})
+
+	env.Set("assertTrue", &BuiltinFunction{
+		Name: "assertTrue",
+		Call: builtinAssertTrue,
+	})
+
+	env.Set("log", &BuiltinFunction{
+		Name: "log",
+		Call: builtinLog,
+	})
+
+	env.Set("readAll", &BuiltinFunction{
+		Name: "readAll",
+		Fn:   builtinReadAll,
 	})
 
 	// Collection functions
@@ -36,7 +118,7 @@ func RegisterBuiltins(env *Environment) {
 
 	env.Set("str", &BuiltinFunction{
 		Name: "str",
-		Fn:   builtinStr,
+		Call: builtinStr,
 	})
 
 	env.Set("int", &BuiltinFunction{
@@ -44,36 +126,389 @@ func RegisterBuiltins(env *Environment) {
 		Fn:   builtinInt,
 	})
 
+	env.Set("bigint", &BuiltinFunction{
+		Name: "bigint",
+		Fn:   builtinBigInt,
+	})
+
+	env.Set("decimal", &BuiltinFunction{
+		Name: "decimal",
+		Fn:   builtinDecimal,
+	})
+
 	env.Set("float", &BuiltinFunction{
 		Name: "float",
 		Fn:   builtinFloat,
 	})
+
+	env.Set("parseInt", &BuiltinFunction{
+		Name: "parseInt",
+		Fn:   builtinParseInt,
+	})
+
+	env.Set("parseFloat", &BuiltinFunction{
+		Name: "parseFloat",
+		Fn:   builtinParseFloat,
+	})
+
+	env.Set("ord", &BuiltinFunction{
+		Name: "ord",
+		Fn:   builtinOrd,
+	})
+
+	env.Set("char", &BuiltinFunction{
+		Name: "char",
+		Fn:   builtinChar,
+	})
+
+	// Bytes and encodings
+	env.Set("bytes", &BuiltinFunction{
+		Name: "bytes",
+		Fn:   builtinBytes,
+	})
+
+	env.Set("base64Encode", &BuiltinFunction{
+		Name: "base64Encode",
+		Fn:   builtinBase64Encode,
+	})
+
+	env.Set("base64Decode", &BuiltinFunction{
+		Name: "base64Decode",
+		Fn:   builtinBase64Decode,
+	})
+
+	env.Set("hexEncode", &BuiltinFunction{
+		Name: "hexEncode",
+		Fn:   builtinHexEncode,
+	})
+
+	env.Set("hexDecode", &BuiltinFunction{
+		Name: "hexDecode",
+		Fn:   builtinHexDecode,
+	})
+
+	env.Set("formatNumber", &BuiltinFunction{
+		Name: "formatNumber",
+		Fn:   builtinFormatNumber,
+	})
+
+	env.Set("repeat", &BuiltinFunction{
+		Name: "repeat",
+		Fn:   builtinRepeat,
+	})
+
+	env.Set("fill", &BuiltinFunction{
+		Name: "fill",
+		Call: builtinFill,
+	})
+
+	// Function helpers
+	env.Set("memoize", &BuiltinFunction{
+		Name: "memoize",
+		Fn:   builtinMemoize,
+	})
+
+	env.Set("compose", &BuiltinFunction{
+		Name: "compose",
+		Fn:   builtinCompose,
+	})
+
+	// Value helpers
+	env.Set("copy", &BuiltinFunction{
+		Name: "copy",
+		Fn:   builtinCopy,
+	})
+
+	env.Set("clone", &BuiltinFunction{
+		Name: "clone",
+		Fn:   builtinCopy,
+	})
+
+	env.Set("hash", &BuiltinFunction{
+		Name: "hash",
+		Fn:   builtinHash,
+	})
+
+	env.Set("toOption", &BuiltinFunction{
+		Name: "toOption",
+		Fn:   builtinToOption,
+	})
+
+	env.Set("toResult", &BuiltinFunction{
+		Name: "toResult",
+		Fn:   builtinToResult,
+	})
+
+	// Process control
+	env.Set("exit", &BuiltinFunction{
+		Name: "exit",
+		Fn:   builtinExit,
+	})
+
+	// Environment variables
+	env.Set("env", &BuiltinFunction{
+		Name: "env",
+		Fn:   builtinEnv,
+	})
+
+	env.Set("envOr", &BuiltinFunction{
+		Name: "envOr",
+		Fn:   builtinEnvOr,
+	})
+
+	// Host functions supplied by an embedder
+	for name, host := range opts.Builtins {
+		RegisterBuiltin(env, name, host.Fn)
+	}
 }
 
-func builtinPrint(args ...Value) Value {
-	var parts []string
-	for _, arg := range args {
-		parts = append(parts, UnwrapValue(arg).String())
+func builtinExit(args ...Value) Value {
+	if len(args) > 1 {
+		return &ErrorValue{Message: "exit() takes at most 1 argument"}
+	}
+	if len(args) == 0 {
+		return &ExitValue{Code: 0}
+	}
+	code, ok := UnwrapValue(args[0]).(*IntegerValue)
+	if !ok {
+		return &ErrorValue{Message: "exit() code must be an integer"}
+	}
+	return &ExitValue{Code: int(code.Value)}
+}
+
+func builtinEnv(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "env() requires exactly 1 argument"}
+	}
+	name, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "env() argument must be a string"}
+	}
+	if val, ok := os.LookupEnv(name.Value); ok {
+		return &OptionValue{IsSome: true, Value: &StringValue{Value: val}}
+	}
+	return &OptionValue{IsSome: false}
+}
+
+func builtinEnvOr(args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "envOr() requires exactly 2 arguments"}
+	}
+	name, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "envOr() name must be a string"}
+	}
+	fallback, ok := UnwrapValue(args[1]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "envOr() default must be a string"}
+	}
+	if val, ok := os.LookupEnv(name.Value); ok {
+		return &StringValue{Value: val}
+	}
+	return fallback
+}
+
+func builtinPrint(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintArgs(eval, args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stdout, text)
+	return &NullValue{}
+}
+
+func builtinPrintln(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintArgs(eval, args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, text)
+	return &NullValue{}
+}
+
+func builtinEprint(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintArgs(eval, args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stderr, text)
+	return &NullValue{}
+}
+
+func builtinEprintln(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintArgs(eval, args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stderr, text)
+	return &NullValue{}
+}
+
+// builtinAssertEqual checks that actual is expected. In test mode a failure is
+// recorded and the program carries on; otherwise it stops with an error.
+func builtinAssertEqual(eval *Evaluator, env *Environment, args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "assertEqual() requires exactly 2 arguments"}
+	}
+	if valuesEqual(args[0], args[1]) {
+		return eval.assertion(nil)
+	}
+	actual, err := assertionText(eval, args[0])
+	if err != nil {
+		return err
+	}
+	expected, err := assertionText(eval, args[1])
+	if err != nil {
+		return err
+	}
+	return eval.assertion(&ErrorValue{Method: "assertEqual",
+		Message: fmt.Sprintf("expected %s, got %s", expected, actual)})
+}
+
+// builtinAssertTrue checks that cond is true, like assertEqual(cond, true)
+func builtinAssertTrue(eval *Evaluator, env *Environment, args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "assertTrue() requires exactly 1 argument"}
+	}
+	cond, ok := UnwrapValue(args[0]).(*BooleanValue)
+	if !ok {
+		return &ErrorValue{Message: fmt.Sprintf("assertTrue() requires a Boolean, got %s", args[0].Type())}
+	}
+	if cond.Value {
+		return eval.assertion(nil)
+	}
+	return eval.assertion(&ErrorValue{Method: "assertTrue", Message: "expected true, got false"})
+}
+
+// assertionText shows a value in an assertion failure. Strings are quoted so
+// that "1" and 1 can be told apart.
+func assertionText(eval *Evaluator, v Value) (string, Value) {
+	if s, ok := UnwrapValue(v).(*StringValue); ok {
+		return strconv.Quote(s.Value), nil
+	}
+	return joinDisplay(eval, []Value{v}, "")
+}
+
+// logLevels are the levels log() accepts
+var logLevels = map[string]bool{"DEBUG": true, "INFO": true, "WARN": true, "ERROR": true}
+
+// builtinLog writes a structured line to stderr: the level, the message and
+// any fields as key=value pairs in key order, e.g.
+// [INFO] user signed in id=42 name="Ada L"
+func builtinLog(eval *Evaluator, env *Environment, args ...Value) Value {
+	if len(args) < 2 || len(args) > 3 {
+		return &ErrorValue{Message: "log() requires 2 or 3 arguments"}
 	}
-	fmt.Print(strings.Join(parts, " "))
+	level, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok || !logLevels[strings.ToUpper(level.Value)] {
+		return &ErrorValue{Method: "log", Input: args[0].String(),
+			Message: "log() level must be one of debug, info, warn or error"}
+	}
+	message, err := joinDisplay(eval, args[1:2], "")
+	if err != nil {
+		return err
+	}
+
+	var line strings.Builder
+	line.WriteString("[" + strings.ToUpper(level.Value) + "] " + message)
+	if len(args) == 3 {
+		fields, ok := UnwrapValue(args[2]).(*MapValue)
+		if !ok {
+			return &ErrorValue{Message: fmt.Sprintf("log() fields must be a map, got %s", args[2].Type())}
+		}
+		for _, key := range sortedKeys(fields.Pairs) {
+			text, err := joinDisplay(eval, []Value{fields.Pairs[key]}, "")
+			if err != nil {
+				return err
+			}
+			line.WriteString(" " + key + "=" + logfmtValue(text))
+		}
+	}
+	fmt.Fprintln(stderr, line.String())
 	return &NullValue{}
 }
 
-func builtinPrintln(args ...Value) Value {
+// logfmtValue quotes a field value when it would otherwise be ambiguous
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// builtinReadAll reads standard input until EOF
+func builtinReadAll(args ...Value) Value {
+	if len(args) != 0 {
+		return &ErrorValue{Message: "readAll() takes no arguments"}
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return &ErrorValue{Message: fmt.Sprintf("readAll(): %s", err)}
+	}
+	return &StringValue{Value: string(data)}
+}
+
+func formatPrintArgs(eval *Evaluator, args []Value) (string, Value) {
+	return joinDisplay(eval, args, " ")
+}
+
+// joinDisplay joins the display forms of values with sep between them
+func joinDisplay(eval *Evaluator, values []Value, sep string) (string, Value) {
 	var parts []string
-	for _, arg := range args {
-		parts = append(parts, UnwrapValue(arg).String())
+	for _, v := range values {
+		text := eval.displayString(v)
+		if isError(text) {
+			return "", text
+		}
+		parts = append(parts, text.(*StringValue).Value)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// formatPrintParts checks the arguments of printParts/printlnParts and joins
+// the list with the separator
+func formatPrintParts(eval *Evaluator, name string, args []Value) (string, Value) {
+	if len(args) != 2 {
+		return "", &ErrorValue{Message: fmt.Sprintf("%s() requires exactly 2 arguments", name)}
+	}
+	list, ok := UnwrapValue(args[0]).(*ListValue)
+	if !ok {
+		return "", &ErrorValue{Message: fmt.Sprintf("%s() parts must be a list, got %s", name, args[0].Type())}
+	}
+	sep, ok := UnwrapValue(args[1]).(*StringValue)
+	if !ok {
+		return "", &ErrorValue{Message: fmt.Sprintf("%s() separator must be a string, got %s", name, args[1].Type())}
+	}
+	return joinDisplay(eval, list.Elements, sep.Value)
+}
+
+// builtinPrintParts prints a list's elements with a chosen separator and
+// no trailing newline
+func builtinPrintParts(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintParts(eval, "printParts", args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stdout, text)
+	return &NullValue{}
+}
+
+func builtinPrintlnParts(eval *Evaluator, env *Environment, args ...Value) Value {
+	text, err := formatPrintParts(eval, "printlnParts", args)
+	if err != nil {
+		return err
 	}
-	fmt.Println(strings.Join(parts, " "))
+	fmt.Fprintln(stdout, text)
 	return &NullValue{}
 }
 
 func builtinRange(args ...Value) Value {
-	if len(args) < 1 || len(args) > 2 {
-		return &ErrorValue{Message: "range() requires 1 or 2 arguments"}
+	if len(args) < 1 || len(args) > 3 {
+		return &ErrorValue{Message: "range() requires 1 to 3 arguments"}
 	}
 
 	var start, end int64
+	step := int64(1)
 
 	if len(args) == 1 {
 		endVal, ok := UnwrapValue(args[0]).(*IntegerValue)
@@ -95,9 +530,27 @@ func builtinRange(args ...Value) Value {
 		end = endVal.Value
 	}
 
-	elements := make([]Value, 0, end-start)
-	for i := start; i < end; i++ {
-		elements = append(elements, &IntegerValue{Value: i})
+	if len(args) == 3 {
+		stepVal, ok := UnwrapValue(args[2]).(*IntegerValue)
+		if !ok {
+			return &ErrorValue{Message: "range() step must be an integer"}
+		}
+		if stepVal.Value == 0 {
+			return &ErrorValue{Message: "range() step cannot be zero"}
+		}
+		step = stepVal.Value
+	}
+
+	elements := []Value{}
+	if step > 0 {
+		for i := start; i < end; i += step {
+			elements = append(elements, &IntegerValue{Value: i})
+		}
+	} else {
+		// Negative step counts down towards end
+		for i := start; i > end; i += step {
+			elements = append(elements, &IntegerValue{Value: i})
+		}
 	}
 
 	return &ListValue{Elements: elements}
@@ -112,8 +565,12 @@ func builtinLen(args ...Value) Value {
 	switch val := arg.(type) {
 	case *StringValue:
 		return &IntegerValue{Value: int64(len(val.Value))}
+	case *BytesValue:
+		return &IntegerValue{Value: int64(len(val.Value))}
 	case *ListValue:
 		return &IntegerValue{Value: int64(len(val.Elements))}
+	case *TupleValue:
+		return &IntegerValue{Value: int64(len(val.Elements))}
 	case *MapValue:
 		return &IntegerValue{Value: int64(len(val.Pairs))}
 	default:
@@ -128,24 +585,92 @@ func builtinType(args ...Value) Value {
 	return &StringValue{Value: UnwrapValue(args[0]).Type()}
 }
 
-func builtinStr(args ...Value) Value {
+func builtinStr(eval *Evaluator, env *Environment, args ...Value) Value {
 	if len(args) != 1 {
 		return &ErrorValue{Message: "str() requires exactly 1 argument"}
 	}
-	return &StringValue{Value: UnwrapValue(args[0]).String()}
+	return eval.displayString(args[0])
 }
 
-func builtinInt(args ...Value) Value {
+// builtinBigInt converts an Integer, or a String of decimal digits, to a BigInt
+func builtinBigInt(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "bigint() requires exactly 1 argument"}
+	}
+	switch val := UnwrapValue(args[0]).(type) {
+	case *IntegerValue:
+		return &BigIntValue{Value: big.NewInt(val.Value)}
+	case *BigIntValue:
+		return val
+	case *StringValue:
+		n, ok := new(big.Int).SetString(strings.TrimSpace(val.Value), 10)
+		if !ok {
+			return &ErrorValue{Method: "bigint", Input: val.Value,
+				Message: fmt.Sprintf("cannot convert %q to BigInt", val.Value)}
+		}
+		return &BigIntValue{Value: n}
+	}
+	return &ErrorValue{Message: fmt.Sprintf("bigint() requires an Integer or String, got %s", args[0].Type())}
+}
+
+// builtinDecimal converts a String such as "0.1", an Integer or a BigInt to
+// a Decimal. Floats are refused, since they already hold a rounded value.
+func builtinDecimal(args ...Value) Value {
 	if len(args) != 1 {
-		return &ErrorValue{Message: "int() requires exactly 1 argument"}
+		return &ErrorValue{Message: "decimal() requires exactly 1 argument"}
+	}
+	switch val := UnwrapValue(args[0]).(type) {
+	case *StringValue:
+		r, ok := new(big.Rat).SetString(strings.TrimSpace(val.Value))
+		if !ok {
+			return &ErrorValue{Method: "decimal", Input: val.Value,
+				Message: fmt.Sprintf("cannot convert %q to Decimal", val.Value)}
+		}
+		return &DecimalValue{Value: r}
+	case *DecimalValue:
+		return val
+	case *FloatValue:
+		return &ErrorValue{Method: "decimal", Input: val.String(),
+			Message: "decimal() of a Float keeps its rounding error; pass a String such as \"0.1\""}
+	}
+	if n, ok := bigIntOf(UnwrapValue(args[0])); ok {
+		return &DecimalValue{Value: new(big.Rat).SetInt(n)}
+	}
+	return &ErrorValue{Message: fmt.Sprintf("decimal() requires a String, Integer or BigInt, got %s", args[0].Type())}
+}
+
+func builtinInt(args ...Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return &ErrorValue{Message: "int() requires 1 or 2 arguments"}
 	}
 
 	arg := UnwrapValue(args[0])
+	if len(args) == 2 {
+		s, ok := arg.(*StringValue)
+		if !ok {
+			return &ErrorValue{Message: "int() with a base requires a string"}
+		}
+		base, err := parseBase("int", args[1])
+		if err != nil {
+			return err
+		}
+		result := stringToIntBase(s, base)
+		if !result.IsOk {
+			return result.Error
+		}
+		return result.Value
+	}
+
 	switch val := arg.(type) {
 	case *IntegerValue:
 		return val
+	case *BigIntValue:
+		if !val.Value.IsInt64() {
+			return &ErrorValue{Message: fmt.Sprintf("%s is too large for an Integer", val.Value)}
+		}
+		return &IntegerValue{Value: val.Value.Int64()}
 	case *FloatValue:
-		return &IntegerValue{Value: int64(val.Value)}
+		return floatToInt(val, "int")
 	case *StringValue:
 		var i int64
 		_, err := fmt.Sscanf(val.Value, "%d", &i)
@@ -186,68 +711,724 @@ func builtinFloat(args ...Value) Value {
 	}
 }
 
-// List methods
-
-func listLength(list *ListValue) Value {
-	return &IntegerValue{Value: int64(len(list.Elements))}
+func builtinCopy(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "copy() requires exactly 1 argument"}
+	}
+	return deepCopy(args[0])
 }
 
-func listGet(list *ListValue, index int64) Value {
-	if index < 0 || index >= int64(len(list.Elements)) {
-		return &OptionValue{IsSome: false}
+// deepCopy copies lists, tuples, maps, structs and the values they hold so
+// the copy shares no mutable state with the original. Scalars and functions
+// are returned as is.
+func deepCopy(v Value) Value {
+	switch val := v.(type) {
+	case *ListValue:
+		return &ListValue{Elements: copyElements(val.Elements)}
+	case *TupleValue:
+		return &TupleValue{Elements: copyElements(val.Elements)}
+	case *MapValue:
+		pairs := make(map[string]Value, len(val.Pairs))
+		for k, elem := range val.Pairs {
+			pairs[k] = deepCopy(elem)
+		}
+		return &MapValue{Pairs: pairs}
+	case *StructValue:
+		fields := make(map[string]Value, len(val.Fields))
+		for k, field := range val.Fields {
+			fields[k] = deepCopy(field)
+		}
+		return &StructValue{Definition: val.Definition, Fields: fields}
+	case *EnumValue:
+		return &EnumValue{Enum: val.Enum, Variant: val.Variant, Values: copyElements(val.Values)}
+	case *OptionValue:
+		if val.IsSome {
+			return &OptionValue{IsSome: true, Value: deepCopy(val.Value)}
+		}
+		return val
+	case *ResultValue:
+		if val.IsOk {
+			return &ResultValue{IsOk: true, Value: deepCopy(val.Value)}
+		}
+		return val
+	case *BytesValue:
+		return &BytesValue{Value: bytes.Clone(val.Value)}
+	case *MutableValue:
+		return &MutableValue{Value: deepCopy(val.Value)}
 	}
-	return &OptionValue{IsSome: true, Value: list.Elements[index]}
+	return v
 }
 
-func listAppend(list *ListValue, val Value) *ListValue {
-	return list.Append(val)
+func copyElements(elements []Value) []Value {
+	copied := make([]Value, len(elements))
+	for i, elem := range elements {
+		copied[i] = deepCopy(elem)
+	}
+	return copied
 }
 
-func listMap(list *ListValue, fn *FunctionValue, eval *Evaluator, env *Environment) *ListValue {
-	newElements := make([]Value, len(list.Elements))
-	for i, elem := range list.Elements {
-		result := eval.applyFunction(fn, []Value{elem}, env)
-		newElements[i] = result
+func builtinHash(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "hash() requires exactly 1 argument"}
 	}
-	return &ListValue{Elements: newElements}
+	return &IntegerValue{Value: hashValue(args[0])}
 }
 
-func listFilter(list *ListValue, fn *FunctionValue, eval *Evaluator, env *Environment) *ListValue {
-	var newElements []Value
-	for _, elem := range list.Elements {
-		result := eval.applyFunction(fn, []Value{elem}, env)
-		if IsTruthy(result) {
-			newElements = append(newElements, elem)
-		}
+// builtinToOption turns null into None and any other value into Some(value)
+func builtinToOption(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "toOption() requires exactly 1 argument"}
 	}
-	return &ListValue{Elements: newElements}
+	if _, ok := UnwrapValue(args[0]).(*NullValue); ok {
+		return &OptionValue{IsSome: false}
+	}
+	return &OptionValue{IsSome: true, Value: args[0]}
 }
 
-func listReduce(list *ListValue, fn *FunctionValue, initial Value, eval *Evaluator, env *Environment) Value {
-	acc := initial
-	for _, elem := range list.Elements {
-		acc = eval.applyFunction(fn, []Value{acc, elem}, env)
+// builtinToResult turns null and None into Error(message), Some(x) into
+// Ok(x) and any other value into Ok(value). A Result is returned unchanged.
+func builtinToResult(args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "toResult() requires exactly 2 arguments"}
+	}
+	msg, ok := UnwrapValue(args[1]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "toResult() message must be a string"}
 	}
-	return acc
-}
 
-func listFind(list *ListValue, fn *FunctionValue, eval *Evaluator, env *Environment) *OptionValue {
-	for _, elem := range list.Elements {
-		result := eval.applyFunction(fn, []Value{elem}, env)
-		if IsTruthy(result) {
-			return &OptionValue{IsSome: true, Value: elem}
+	switch v := UnwrapValue(args[0]).(type) {
+	case *NullValue:
+		return &ResultValue{IsOk: false, Error: &ErrorValue{Message: msg.Value}}
+	case *OptionValue:
+		if !v.IsSome {
+			return &ResultValue{IsOk: false, Error: &ErrorValue{Message: msg.Value}}
 		}
+		return &ResultValue{IsOk: true, Value: v.Value}
+	case *ResultValue:
+		return v
 	}
-	return &OptionValue{IsSome: false}
+	return &ResultValue{IsOk: true, Value: args[0]}
 }
 
-func listContains(list *ListValue, val Value) bool {
-	for _, elem := range list.Elements {
-		if valuesEqual(elem, val) {
-			return true
-		}
-	}
-	return false
+// hashValue returns a stable hash of v built from hashKey, so values that are
+// equal according to valuesEqual hash equally
+func hashValue(v Value) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(hashKey(v)))
+	return int64(h.Sum64())
+}
+
+// builtinRepeat builds a list of n copies of a value. The parser only
+// treats `repeat {` as a loop, so repeat(...) reaches this builtin.
+func builtinRepeat(args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "repeat() requires exactly 2 arguments"}
+	}
+	n, ok := UnwrapValue(args[1]).(*IntegerValue)
+	if !ok {
+		return &ErrorValue{Message: "repeat() count must be an integer"}
+	}
+	value := UnwrapValue(args[0])
+	elements := make([]Value, max(n.Value, 0))
+	for i := range elements {
+		elements[i] = value
+	}
+	return &ListValue{Elements: elements}
+}
+
+func builtinFill(eval *Evaluator, env *Environment, args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "fill() requires exactly 2 arguments"}
+	}
+	n, ok := UnwrapValue(args[0]).(*IntegerValue)
+	if !ok {
+		return &ErrorValue{Message: "fill() count must be an integer"}
+	}
+	if !isCallable(args[1]) {
+		return &ErrorValue{Message: "fill() second argument must be a function"}
+	}
+	elements := make([]Value, max(n.Value, 0))
+	for i := range elements {
+		result := eval.applyFunction(args[1], []Value{&IntegerValue{Value: int64(i)}}, env)
+		if isError(result) {
+			return result
+		}
+		elements[i] = result
+	}
+	return &ListValue{Elements: elements}
+}
+
+// builtinParseInt is like int() for strings but returns a Result, so a bad
+// input can be handled with match instead of stopping the program
+func builtinParseInt(args ...Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return &ErrorValue{Message: "parseInt() requires 1 or 2 arguments"}
+	}
+	s, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "parseInt() argument must be a string"}
+	}
+	if len(args) == 2 {
+		base, err := parseBase("parseInt", args[1])
+		if err != nil {
+			return err
+		}
+		return stringToIntBase(s, base)
+	}
+	return stringToInt(s)
+}
+
+// parseBase validates a radix argument for int() and parseInt()
+func parseBase(name string, arg Value) (int, *ErrorValue) {
+	base, ok := UnwrapValue(arg).(*IntegerValue)
+	if !ok || base.Value < 2 || base.Value > 36 {
+		return 0, &ErrorValue{Message: fmt.Sprintf("%s() base must be an integer from 2 to 36", name)}
+	}
+	return int(base.Value), nil
+}
+
+func builtinParseFloat(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "parseFloat() requires exactly 1 argument"}
+	}
+	s, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "parseFloat() argument must be a string"}
+	}
+	return stringToFloat(s)
+}
+
+// builtinOrd returns the code point of the first character of a string
+func builtinOrd(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "ord() requires exactly 1 argument"}
+	}
+	s, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "ord() argument must be a string"}
+	}
+	if s.Value == "" {
+		return &ErrorValue{Method: "ord", Input: `""`, Message: "ord() of empty string"}
+	}
+	r, _ := utf8.DecodeRuneInString(s.Value)
+	return &IntegerValue{Value: int64(r)}
+}
+
+// builtinChar returns the single-character string for a code point
+func builtinChar(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "char() requires exactly 1 argument"}
+	}
+	n, ok := UnwrapValue(args[0]).(*IntegerValue)
+	if !ok {
+		return &ErrorValue{Message: "char() argument must be an integer"}
+	}
+	if n.Value < 0 || n.Value > utf8.MaxRune || !utf8.ValidRune(rune(n.Value)) {
+		return &ErrorValue{Method: "char", Input: n.String(), Message: "char() argument is not a valid code point"}
+	}
+	return &StringValue{Value: string(rune(n.Value))}
+}
+
+// builtinBytes converts a string (as UTF-8) or a list of integers 0-255 to Bytes
+func builtinBytes(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "bytes() requires exactly 1 argument"}
+	}
+	switch val := UnwrapValue(args[0]).(type) {
+	case *BytesValue:
+		return val
+	case *StringValue:
+		return &BytesValue{Value: []byte(val.Value)}
+	case *ListValue:
+		data := make([]byte, len(val.Elements))
+		for i, elem := range val.Elements {
+			n, ok := UnwrapValue(elem).(*IntegerValue)
+			if !ok || n.Value < 0 || n.Value > 255 {
+				return &ErrorValue{Method: "bytes", Input: elem.String(),
+					Message: fmt.Sprintf("bytes() element %d must be an integer from 0 to 255", i)}
+			}
+			data[i] = byte(n.Value)
+		}
+		return &BytesValue{Value: data}
+	default:
+		return &ErrorValue{Message: fmt.Sprintf("cannot convert %s to bytes", val.Type())}
+	}
+}
+
+func builtinBase64Encode(args ...Value) Value {
+	data, err := bytesArgument("base64Encode", args)
+	if err != nil {
+		return err
+	}
+	return &StringValue{Value: base64.StdEncoding.EncodeToString(data.Value)}
+}
+
+func builtinBase64Decode(args ...Value) Value {
+	s, err := stringArgument("base64Decode", args)
+	if err != nil {
+		return err
+	}
+	data, decodeErr := base64.StdEncoding.DecodeString(s.Value)
+	if decodeErr != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{Message: fmt.Sprintf("invalid base64: %s", decodeErr)}}
+	}
+	return &ResultValue{IsOk: true, Value: &BytesValue{Value: data}}
+}
+
+func builtinHexEncode(args ...Value) Value {
+	data, err := bytesArgument("hexEncode", args)
+	if err != nil {
+		return err
+	}
+	return &StringValue{Value: hex.EncodeToString(data.Value)}
+}
+
+func builtinHexDecode(args ...Value) Value {
+	s, err := stringArgument("hexDecode", args)
+	if err != nil {
+		return err
+	}
+	data, decodeErr := hex.DecodeString(s.Value)
+	if decodeErr != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{Message: fmt.Sprintf("invalid hex: %s", decodeErr)}}
+	}
+	return &ResultValue{IsOk: true, Value: &BytesValue{Value: data}}
+}
+
+func bytesArgument(name string, args []Value) (*BytesValue, *ErrorValue) {
+	if len(args) != 1 {
+		return nil, &ErrorValue{Message: fmt.Sprintf("%s() requires exactly 1 argument", name)}
+	}
+	data, ok := UnwrapValue(args[0]).(*BytesValue)
+	if !ok {
+		return nil, &ErrorValue{Message: fmt.Sprintf("%s() argument must be Bytes, got %s", name, UnwrapValue(args[0]).Type())}
+	}
+	return data, nil
+}
+
+func stringArgument(name string, args []Value) (*StringValue, *ErrorValue) {
+	if len(args) != 1 {
+		return nil, &ErrorValue{Message: fmt.Sprintf("%s() requires exactly 1 argument", name)}
+	}
+	s, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return nil, &ErrorValue{Message: fmt.Sprintf("%s() argument must be a string", name)}
+	}
+	return s, nil
+}
+
+// builtinFormatNumber formats a number with thousands separators and a fixed
+// number of decimals: formatNumber(1234567.5, 2) is "1,234,567.50"
+func builtinFormatNumber(args ...Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return &ErrorValue{Message: "formatNumber() requires 1 or 2 arguments"}
+	}
+	decimals := int64(0)
+	if len(args) == 2 {
+		d, ok := UnwrapValue(args[1]).(*IntegerValue)
+		if !ok || d.Value < 0 {
+			return &ErrorValue{Message: "formatNumber() decimals must be a non-negative integer"}
+		}
+		decimals = d.Value
+	}
+
+	var digits string
+	switch n := UnwrapValue(args[0]).(type) {
+	case *IntegerValue:
+		digits = strconv.FormatInt(n.Value, 10)
+		if decimals > 0 {
+			digits += "." + strings.Repeat("0", int(decimals))
+		}
+	case *FloatValue:
+		digits = strconv.FormatFloat(n.Value, 'f', int(decimals), 64)
+	default:
+		return &ErrorValue{Message: fmt.Sprintf("formatNumber() requires a number, got %s", n.Type())}
+	}
+
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		digits = digits[1:]
+		// Don't show a sign when rounding leaves nothing but zeros
+		if strings.Trim(digits, "0.") != "" {
+			sign = "-"
+		}
+	}
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+
+	var out strings.Builder
+	out.WriteString(sign)
+	for i, ch := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			out.WriteByte(',')
+		}
+		out.WriteRune(ch)
+	}
+	if hasFrac {
+		out.WriteByte('.')
+		out.WriteString(frac)
+	}
+	return &StringValue{Value: out.String()}
+}
+
+func builtinMemoize(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "memoize() requires exactly 1 argument"}
+	}
+	if !isCallable(args[0]) {
+		return &ErrorValue{Message: "memoize() argument must be a function"}
+	}
+	return &MemoizedFunction{Fn: args[0], Cache: make(map[string]Value)}
+}
+
+func builtinCompose(args ...Value) Value {
+	if len(args) == 0 {
+		return &ErrorValue{Message: "compose() requires at least 1 argument"}
+	}
+	for _, arg := range args {
+		if !isCallable(arg) {
+			return &ErrorValue{Message: "compose() arguments must be functions"}
+		}
+	}
+	return &ComposedFunction{Functions: args}
+}
+
+// isCallable reports whether a value can be applied to arguments
+func isCallable(v Value) bool {
+	switch v.(type) {
+	case *FunctionValue, *BuiltinFunction, *MemoizedFunction, *ComposedFunction:
+		return true
+	}
+	return false
+}
+
+// List methods
+
+func listLength(list *ListValue) Value {
+	return &IntegerValue{Value: int64(len(list.Elements))}
+}
+
+func listGet(list *ListValue, index int64) Value {
+	if index < 0 || index >= int64(len(list.Elements)) {
+		return &OptionValue{IsSome: false}
+	}
+	return &OptionValue{IsSome: true, Value: list.Elements[index]}
+}
+
+func listAppend(list *ListValue, val Value) *ListValue {
+	return list.Append(val)
+}
+
+func listMap(list *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	newElements := make([]Value, len(list.Elements))
+	for i, elem := range list.Elements {
+		result := eval.applyFunction(fn, []Value{elem}, env)
+		if exit, ok := result.(*ExitValue); ok {
+			return exit
+		}
+		newElements[i] = result
+	}
+	return &ListValue{Elements: newElements}
+}
+
+func listFilter(list *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	var newElements []Value
+	for _, elem := range list.Elements {
+		result := eval.applyFunction(fn, []Value{elem}, env)
+		if exit, ok := result.(*ExitValue); ok {
+			return exit
+		}
+		if IsTruthy(result) {
+			newElements = append(newElements, elem)
+		}
+	}
+	return &ListValue{Elements: newElements}
+}
+
+func listReduce(list *ListValue, fn Value, initial Value, eval *Evaluator, env *Environment) Value {
+	acc := initial
+	for _, elem := range list.Elements {
+		acc = eval.applyFunction(fn, []Value{acc, elem}, env)
+		if exit, ok := acc.(*ExitValue); ok {
+			return exit
+		}
+	}
+	return acc
+}
+
+func listFind(list *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	for _, elem := range list.Elements {
+		result := eval.applyFunction(fn, []Value{elem}, env)
+		if exit, ok := result.(*ExitValue); ok {
+			return exit
+		}
+		if IsTruthy(result) {
+			return &OptionValue{IsSome: true, Value: elem}
+		}
+	}
+	return &OptionValue{IsSome: false}
+}
+
+// listUnique removes duplicates, keeping the first occurrence of each value
+func listUnique(list *ListValue) *ListValue {
+	seen := make(map[string]bool)
+	newElements := []Value{}
+	for _, elem := range list.Elements {
+		key := hashKey(elem)
+		if !seen[key] {
+			seen[key] = true
+			newElements = append(newElements, elem)
+		}
+	}
+	return &ListValue{Elements: newElements}
+}
+
+// listExtremeBy returns the element whose key is largest (or smallest when
+// max is false); ties keep the first element and an empty list gives None
+func listExtremeBy(list *ListValue, fn Value, max bool, eval *Evaluator, env *Environment) Value {
+	var best, bestKey Value
+	for _, elem := range list.Elements {
+		key := UnwrapValue(eval.applyFunction(fn, []Value{elem}, env))
+		if isError(key) {
+			return key
+		}
+		if best == nil {
+			best, bestKey = elem, key
+			continue
+		}
+		cmp, err := eval.compareOrdered(key, bestKey)
+		if err != nil {
+			return err
+		}
+		if (max && cmp > 0) || (!max && cmp < 0) {
+			best, bestKey = elem, key
+		}
+	}
+	if best == nil {
+		return &OptionValue{IsSome: false}
+	}
+	return &OptionValue{IsSome: true, Value: best}
+}
+
+// listExtreme returns the largest (or smallest when max is false) element;
+// ties keep the first element and an empty list gives None
+func listExtreme(list *ListValue, max bool, eval *Evaluator) Value {
+	if len(list.Elements) == 0 {
+		return &OptionValue{IsSome: false}
+	}
+	best := list.Elements[0]
+	for _, elem := range list.Elements[1:] {
+		cmp, err := eval.compareOrdered(elem, best)
+		if err != nil {
+			return err
+		}
+		if (max && cmp > 0) || (!max && cmp < 0) {
+			best = elem
+		}
+	}
+	return &OptionValue{IsSome: true, Value: best}
+}
+
+// listSort returns a new list with the elements in ascending order. The sort
+// is stable, so equal elements keep their original order.
+func listSort(list *ListValue, eval *Evaluator) Value {
+	sorted := make([]Value, len(list.Elements))
+	copy(sorted, list.Elements)
+	var failed Value
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		cmp, err := eval.compareOrdered(sorted[i], sorted[j])
+		if err != nil {
+			failed = err
+			return false
+		}
+		return cmp < 0
+	})
+	if failed != nil {
+		return failed
+	}
+	return &ListValue{Elements: sorted}
+}
+
+// compareOrdered orders a and b like compareValues, and also orders structs
+// whose type has a compare extension method. compare returns a negative,
+// zero or positive Integer, like -1, 0 or 1.
+func (e *Evaluator) compareOrdered(a, b Value) (int, Value) {
+	a, b = UnwrapValue(a), UnwrapValue(b)
+	if structVal, ok := a.(*StructValue); ok {
+		method, ok := e.extensions[structVal.Type()]["compare"]
+		if !ok {
+			return 0, &ErrorValue{Message: fmt.Sprintf("cannot compare %s values (extend %s with a compare method)",
+				structVal.Type(), structVal.Type())}
+		}
+		result := e.callExtensionMethod(method, structVal, []Value{b})
+		if isError(result) {
+			return 0, result
+		}
+		n, ok := UnwrapValue(result).(*IntegerValue)
+		if !ok {
+			return 0, &ErrorValue{Message: fmt.Sprintf("%s.compare() must return an Integer, got %s", structVal.Type(), result.Type())}
+		}
+		switch {
+		case n.Value < 0:
+			return -1, nil
+		case n.Value > 0:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	cmp, ok := compareValues(a, b)
+	if !ok {
+		return 0, &ErrorValue{Message: fmt.Sprintf("cannot compare %s and %s", a.Type(), b.Type())}
+	}
+	return cmp, nil
+}
+
+// compareValues orders two numbers or two strings, returning -1, 0 or 1;
+// ok is false when the values can't be ordered
+func compareValues(a, b Value) (cmp int, ok bool) {
+	if as, isStr := a.(*StringValue); isStr {
+		if bs, isStr := b.(*StringValue); isStr {
+			return strings.Compare(as.Value, bs.Value), true
+		}
+		return 0, false
+	}
+	if ai, isInt := a.(*IntegerValue); isInt {
+		if bi, isInt := b.(*IntegerValue); isInt {
+			switch {
+			case ai.Value < bi.Value:
+				return -1, true
+			case ai.Value > bi.Value:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if an, ok := bigIntOf(a); ok {
+		if bn, ok := bigIntOf(b); ok {
+			return an.Cmp(bn), true
+		}
+	}
+	if ad, ok := decimalOf(a); ok {
+		if bd, ok := decimalOf(b); ok {
+			return ad.Cmp(bd), true
+		}
+	}
+	x, xok := numberAsFloat(a)
+	y, yok := numberAsFloat(b)
+	if !xok || !yok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+func numberAsFloat(v Value) (float64, bool) {
+	switch n := v.(type) {
+	case *IntegerValue:
+		return float64(n.Value), true
+	case *FloatValue:
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// listPartition splits a list into [matching, notMatching] in a single pass
+func listPartition(list *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	matching, rest := []Value{}, []Value{}
+	for _, elem := range list.Elements {
+		result := eval.applyFunction(fn, []Value{elem}, env)
+		if isError(result) {
+			return result
+		}
+		if IsTruthy(result) {
+			matching = append(matching, elem)
+		} else {
+			rest = append(rest, elem)
+		}
+	}
+	return &ListValue{Elements: []Value{
+		&ListValue{Elements: matching},
+		&ListValue{Elements: rest},
+	}}
+}
+
+// listGroupBy collects elements into lists keyed by fn(element), keeping their order
+func listGroupBy(list *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	groups := make(map[string][]Value)
+	for _, elem := range list.Elements {
+		result := UnwrapValue(eval.applyFunction(fn, []Value{elem}, env))
+		if isError(result) {
+			return result
+		}
+		key, ok := result.(*StringValue)
+		if !ok {
+			return &ErrorValue{Message: fmt.Sprintf("groupBy() key must be a string, got %s", result.Type())}
+		}
+		groups[key.Value] = append(groups[key.Value], elem)
+	}
+
+	pairs := make(map[string]Value, len(groups))
+	for key, elements := range groups {
+		pairs[key] = &ListValue{Elements: elements}
+	}
+	return &MapValue{Pairs: pairs}
+}
+
+// listZipWith combines two lists element-wise with fn, stopping at the end of
+// the shorter list
+func listZipWith(list, other *ListValue, fn Value, eval *Evaluator, env *Environment) Value {
+	n := min(len(list.Elements), len(other.Elements))
+	elements := make([]Value, n)
+	for i := 0; i < n; i++ {
+		result := eval.applyFunction(fn, []Value{list.Elements[i], other.Elements[i]}, env)
+		if exit, ok := result.(*ExitValue); ok {
+			return exit
+		}
+		elements[i] = result
+	}
+	return &ListValue{Elements: elements}
+}
+
+// listToMap builds a map from [key, value] pairs, the inverse of items().
+// Later pairs overwrite earlier ones with the same key.
+func listToMap(list *ListValue) Value {
+	pairs := make(map[string]Value, len(list.Elements))
+	for i, elem := range list.Elements {
+		var kv []Value
+		switch pair := UnwrapValue(elem).(type) {
+		case *ListValue:
+			kv = pair.Elements
+		case *TupleValue:
+			kv = pair.Elements
+		}
+		if len(kv) != 2 {
+			return &ErrorValue{Method: "toMap", Input: elem.String(),
+				Message: fmt.Sprintf("toMap() element %d must be a [key, value] pair", i)}
+		}
+		key, ok := UnwrapValue(kv[0]).(*StringValue)
+		if !ok {
+			return &ErrorValue{Method: "toMap", Input: elem.String(),
+				Message: fmt.Sprintf("toMap() key must be a string, got %s", UnwrapValue(kv[0]).Type())}
+		}
+		pairs[key.Value] = kv[1]
+	}
+	return &MapValue{Pairs: pairs}
+}
+
+func listContains(list *ListValue, val Value) bool {
+	for _, elem := range list.Elements {
+		if valuesEqual(elem, val) {
+			return true
+		}
+	}
+	return false
 }
 
 // Map methods
@@ -278,9 +1459,20 @@ func mapRemove(m *MapValue, key string) *MapValue {
 	return &MapValue{Pairs: newPairs}
 }
 
+// sortedKeys returns the keys of a map in sorted order, so keys(), values()
+// and items() line up index by index
+func sortedKeys(pairs map[string]Value) []string {
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func mapKeys(m *MapValue) *ListValue {
 	keys := make([]Value, 0, len(m.Pairs))
-	for k := range m.Pairs {
+	for _, k := range sortedKeys(m.Pairs) {
 		keys = append(keys, &StringValue{Value: k})
 	}
 	return &ListValue{Elements: keys}
@@ -288,12 +1480,21 @@ func mapKeys(m *MapValue) *ListValue {
 
 func mapValues(m *MapValue) *ListValue {
 	values := make([]Value, 0, len(m.Pairs))
-	for _, v := range m.Pairs {
-		values = append(values, v)
+	for _, k := range sortedKeys(m.Pairs) {
+		values = append(values, m.Pairs[k])
 	}
 	return &ListValue{Elements: values}
 }
 
+func mapItems(m *MapValue) *ListValue {
+	items := make([]Value, 0, len(m.Pairs))
+	for _, k := range sortedKeys(m.Pairs) {
+		pair := &ListValue{Elements: []Value{&StringValue{Value: k}, m.Pairs[k]}}
+		items = append(items, pair)
+	}
+	return &ListValue{Elements: items}
+}
+
 func mapContains(m *MapValue, key string) bool {
 	_, ok := m.Pairs[key]
 	return ok
@@ -314,6 +1515,76 @@ func stringSplit(s *StringValue, sep string) *ListValue {
 	return &ListValue{Elements: elements}
 }
 
+// stringSplitN splits into at most n parts; the last part holds the remainder
+func stringSplitN(s *StringValue, sep string, n int) *ListValue {
+	parts := strings.SplitN(s.Value, sep, n)
+	elements := make([]Value, len(parts))
+	for i, p := range parts {
+		elements[i] = &StringValue{Value: p}
+	}
+	return &ListValue{Elements: elements}
+}
+
+func compileRegex(method, pattern string) (*regexp.Regexp, *ErrorValue) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &ErrorValue{Method: method, Input: pattern, Message: fmt.Sprintf("invalid regex: %s", err)}
+	}
+	return re, nil
+}
+
+// stringFindAll returns every match of re. Without capture groups each item is
+// the whole match; with one group it is that group, and with several it is a
+// list of the groups.
+func stringFindAll(s *StringValue, re *regexp.Regexp) *ListValue {
+	elements := []Value{}
+	for _, m := range re.FindAllStringSubmatch(s.Value, -1) {
+		switch len(m) {
+		case 1:
+			elements = append(elements, &StringValue{Value: m[0]})
+		case 2:
+			elements = append(elements, &StringValue{Value: m[1]})
+		default:
+			groups := make([]Value, len(m)-1)
+			for i, g := range m[1:] {
+				groups[i] = &StringValue{Value: g}
+			}
+			elements = append(elements, &ListValue{Elements: groups})
+		}
+	}
+	return &ListValue{Elements: elements}
+}
+
+// stringSplitLines splits on \n or \r\n, ignoring a single trailing line break
+func stringSplitLines(s *StringValue) *ListValue {
+	elements := []Value{}
+	if s.Value == "" {
+		return &ListValue{Elements: elements}
+	}
+	text := strings.TrimSuffix(s.Value, "\n")
+	for _, line := range strings.Split(text, "\n") {
+		elements = append(elements, &StringValue{Value: strings.TrimSuffix(line, "\r")})
+	}
+	return &ListValue{Elements: elements}
+}
+
+// stringPad pads s with repetitions of pad up to width characters
+// maxPadWidth bounds padLeft and padRight so a stray width can't
+// allocate an enormous string
+const maxPadWidth = 1 << 20
+
+func stringPad(s *StringValue, width int, pad string, left bool) *StringValue {
+	missing := width - utf8.RuneCountInString(s.Value)
+	if missing <= 0 {
+		return s
+	}
+	padRunes := []rune(strings.Repeat(pad, missing))[:missing]
+	if left {
+		return &StringValue{Value: string(padRunes) + s.Value}
+	}
+	return &StringValue{Value: s.Value + string(padRunes)}
+}
+
 func stringContains(s *StringValue, substr string) bool {
 	return strings.Contains(s.Value, substr)
 }
@@ -330,6 +1601,328 @@ func stringLower(s *StringValue) *StringValue {
 	return &StringValue{Value: strings.ToLower(s.Value)}
 }
 
+func stringToInt(s *StringValue) *ResultValue {
+	i, err := strconv.ParseInt(strings.TrimSpace(s.Value), 10, 64)
+	if err != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{
+			Message: fmt.Sprintf("cannot convert %q to integer", s.Value),
+		}}
+	}
+	return &ResultValue{IsOk: true, Value: &IntegerValue{Value: i}}
+}
+
+func stringToIntBase(s *StringValue, base int) *ResultValue {
+	i, err := strconv.ParseInt(strings.TrimSpace(s.Value), base, 64)
+	if err != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{
+			Message: fmt.Sprintf("cannot convert %q to integer in base %d", s.Value, base),
+		}}
+	}
+	return &ResultValue{IsOk: true, Value: &IntegerValue{Value: i}}
+}
+
+func stringToFloat(s *StringValue) *ResultValue {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s.Value), 64)
+	if err != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{
+			Message: fmt.Sprintf("cannot convert %q to float", s.Value),
+		}}
+	}
+	return &ResultValue{IsOk: true, Value: &FloatValue{Value: f}}
+}
+
+// Struct methods
+
+func structFields(s *StructValue) *MapValue {
+	pairs := make(map[string]Value, len(s.Fields))
+	for k, v := range s.Fields {
+		pairs[k] = v
+	}
+	return &MapValue{Pairs: pairs}
+}
+
+func structFieldNames(s *StructValue) *ListValue {
+	names := sortedKeys(s.Fields)
+	elements := make([]Value, len(names))
+	for i, name := range names {
+		elements[i] = &StringValue{Value: name}
+	}
+	return &ListValue{Elements: elements}
+}
+
+// Bytes methods
+
+func bytesToList(b *BytesValue) *ListValue {
+	elements := make([]Value, len(b.Value))
+	for i, c := range b.Value {
+		elements[i] = &IntegerValue{Value: int64(c)}
+	}
+	return &ListValue{Elements: elements}
+}
+
+// Mutable methods
+
+// mutableStep adds sign*n (default 1) to a mutable number in place and
+// returns the new value.
+func mutableStep(mv *MutableValue, method string, sign int64, args []Value) Value {
+	if len(args) > 1 {
+		return &ErrorValue{Method: method, Message: fmt.Sprintf("%s() takes at most 1 argument", method)}
+	}
+	step := Value(&IntegerValue{Value: 1})
+	if len(args) == 1 {
+		step = UnwrapValue(args[0])
+	}
+
+	switch cur := mv.Value.(type) {
+	case *IntegerValue:
+		n, ok := step.(*IntegerValue)
+		if !ok {
+			return &ErrorValue{Method: method, Input: step.String(),
+				Message: fmt.Sprintf("%s() step must be an Integer, got %s", method, step.Type())}
+		}
+		result, ok := checkedArithmetic("+", cur.Value, sign*n.Value)
+		if !ok || (sign < 0 && n.Value == math.MinInt64) {
+			return &ErrorValue{Method: method, Input: step.String(),
+				Message: fmt.Sprintf("integer overflow: %s(%d) on %d", method, n.Value, cur.Value)}
+		}
+		mv.Value = &IntegerValue{Value: result}
+	case *BigIntValue:
+		n, ok := bigIntOf(step)
+		if !ok {
+			return &ErrorValue{Method: method, Input: step.String(),
+				Message: fmt.Sprintf("%s() step must be an Integer, got %s", method, step.Type())}
+		}
+		if sign < 0 {
+			n = new(big.Int).Neg(n)
+		}
+		mv.Value = &BigIntValue{Value: new(big.Int).Add(cur.Value, n)}
+	case *FloatValue:
+		n, ok := numberAsFloat(step)
+		if !ok {
+			return &ErrorValue{Method: method, Input: step.String(),
+				Message: fmt.Sprintf("%s() step must be a number, got %s", method, step.Type())}
+		}
+		mv.Value = &FloatValue{Value: cur.Value + float64(sign)*n}
+	default:
+		return &ErrorValue{Method: method, Input: mv.Value.String(),
+			Message: fmt.Sprintf("%s() requires a mutable number, got %s", method, mv.Value.Type())}
+	}
+	return mv.Value
+}
+
+// Number methods
+
+// integerAbs returns |i|, failing for the smallest Integer whose absolute
+// value does not fit
+func integerAbs(i *IntegerValue) Value {
+	if i.Value == math.MinInt64 {
+		return &ErrorValue{Method: "abs", Input: i.String(),
+			Message: fmt.Sprintf("integer overflow: abs(%d)", i.Value)}
+	}
+	if i.Value < 0 {
+		return &IntegerValue{Value: -i.Value}
+	}
+	return i
+}
+
+func integerSign(i *IntegerValue) *IntegerValue {
+	switch {
+	case i.Value > 0:
+		return &IntegerValue{Value: 1}
+	case i.Value < 0:
+		return &IntegerValue{Value: -1}
+	}
+	return &IntegerValue{Value: 0}
+}
+
+func floatAbs(f *FloatValue) *FloatValue {
+	return &FloatValue{Value: math.Abs(f.Value)}
+}
+
+func floatSign(f *FloatValue) *IntegerValue {
+	switch {
+	case f.Value > 0:
+		return &IntegerValue{Value: 1}
+	case f.Value < 0:
+		return &IntegerValue{Value: -1}
+	}
+	return &IntegerValue{Value: 0}
+}
+
+func floatFloor(f *FloatValue) *FloatValue {
+	return &FloatValue{Value: math.Floor(f.Value)}
+}
+
+func floatCeil(f *FloatValue) *FloatValue {
+	return &FloatValue{Value: math.Ceil(f.Value)}
+}
+
+func floatRound(f *FloatValue) *FloatValue {
+	return &FloatValue{Value: math.Round(f.Value)}
+}
+
+// floatToInt truncates f toward zero, failing for NaN, the infinities and
+// values outside the Integer range
+func floatToInt(f *FloatValue, method string) Value {
+	if math.IsNaN(f.Value) {
+		return &ErrorValue{Method: method, Input: f.String(), Message: "cannot convert NaN to an Integer"}
+	}
+	if math.IsInf(f.Value, 0) || f.Value < math.MinInt64 || f.Value >= math.MaxInt64 {
+		return &ErrorValue{Method: method, Input: f.String(),
+			Message: fmt.Sprintf("%s is out of range for an Integer", f.String())}
+	}
+	return &IntegerValue{Value: int64(f.Value)}
+}
+
+// decimalRound rounds d to the given number of decimal places, with halves
+// rounded away from zero like Float's round
+func decimalRound(d *DecimalValue, places int64) *DecimalValue {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(places), nil)
+	scaled := new(big.Rat).Mul(d.Value, new(big.Rat).SetInt(scale))
+
+	num := new(big.Int).Abs(scaled.Num())
+	q, r := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+	if new(big.Int).Lsh(r, 1).Cmp(scaled.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if scaled.Sign() < 0 {
+		q.Neg(q)
+	}
+	return &DecimalValue{Value: new(big.Rat).SetFrac(q, scale)}
+}
+
+// JSON conversion
+
+// valueToJSON encodes a value as a JSON string. Structs encode as objects,
+// Some(x) and Ok(x) as x, None as null and Error(e) as {"error": e}.
+func valueToJSON(v Value) Value {
+	data, err := toJSONData(v)
+	if err != nil {
+		return &ErrorValue{Message: fmt.Sprintf("toJSON(): %s", err)}
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return &ErrorValue{Message: fmt.Sprintf("toJSON(): %s", err)}
+	}
+	return &StringValue{Value: string(out)}
+}
+
+func toJSONData(v Value) (interface{}, error) {
+	switch val := UnwrapValue(v).(type) {
+	case *IntegerValue:
+		return val.Value, nil
+	case *BigIntValue:
+		return json.Number(val.Value.String()), nil
+	case *DecimalValue:
+		return json.Number(val.String()), nil
+	case *FloatValue:
+		return val.Value, nil
+	case *StringValue:
+		return val.Value, nil
+	case *BooleanValue:
+		return val.Value, nil
+	case *NullValue:
+		return nil, nil
+	case *BytesValue:
+		return base64.StdEncoding.EncodeToString(val.Value), nil
+	case *ListValue:
+		items := make([]interface{}, len(val.Elements))
+		for i, elem := range val.Elements {
+			item, err := toJSONData(elem)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case *TupleValue:
+		return toJSONData(&ListValue{Elements: val.Elements})
+	case *MapValue:
+		return fieldsToJSONData(val.Pairs)
+	case *StructValue:
+		return fieldsToJSONData(val.Fields)
+	case *EnumValue:
+		// A plain variant is its name; one with a payload is {"Variant": [values]}
+		if len(val.Values) == 0 {
+			return val.Variant, nil
+		}
+		values, err := toJSONData(&ListValue{Elements: val.Values})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{val.Variant: values}, nil
+	case *OptionValue:
+		if !val.IsSome {
+			return nil, nil
+		}
+		return toJSONData(val.Value)
+	case *ResultValue:
+		if !val.IsOk {
+			return map[string]interface{}{"error": val.Error.Message}, nil
+		}
+		return toJSONData(val.Value)
+	default:
+		return nil, fmt.Errorf("cannot encode %s as JSON", val.Type())
+	}
+}
+
+func fieldsToJSONData(fields map[string]Value) (interface{}, error) {
+	obj := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		item, err := toJSONData(v)
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = item
+	}
+	return obj, nil
+}
+
+// jsonToValue decodes a JSON string into a Result holding the value
+func jsonToValue(text string) *ResultValue {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{Message: fmt.Sprintf("invalid JSON: %s", err)}}
+	}
+	if decoder.More() {
+		return &ResultValue{IsOk: false, Error: &ErrorValue{Message: "invalid JSON: unexpected data after value"}}
+	}
+	return &ResultValue{IsOk: true, Value: fromJSONData(data)}
+}
+
+func fromJSONData(data interface{}) Value {
+	switch d := data.(type) {
+	case nil:
+		return &NullValue{}
+	case bool:
+		return &BooleanValue{Value: d}
+	case string:
+		return &StringValue{Value: d}
+	case json.Number:
+		if i, err := d.Int64(); err == nil {
+			return &IntegerValue{Value: i}
+		}
+		f, _ := d.Float64()
+		return &FloatValue{Value: f}
+	case []interface{}:
+		elements := make([]Value, len(d))
+		for i, item := range d {
+			elements[i] = fromJSONData(item)
+		}
+		return &ListValue{Elements: elements}
+	case map[string]interface{}:
+		pairs := make(map[string]Value, len(d))
+		for k, item := range d {
+			pairs[k] = fromJSONData(item)
+		}
+		return &MapValue{Pairs: pairs}
+	}
+	return &NullValue{}
+}
+
 // Helper function to compare values
 func valuesEqual(a, b Value) bool {
 	a = UnwrapValue(a)
@@ -337,12 +1930,33 @@ func valuesEqual(a, b Value) bool {
 
 	switch av := a.(type) {
 	case *IntegerValue:
-		if bv, ok := b.(*IntegerValue); ok {
+		switch bv := b.(type) {
+		case *IntegerValue:
 			return av.Value == bv.Value
+		case *BigIntValue:
+			return bv.Value.IsInt64() && bv.Value.Int64() == av.Value
+		case *DecimalValue:
+			return bv.Value.IsInt() && bv.Value.Num().IsInt64() && bv.Value.Num().Int64() == av.Value
+		case *FloatValue:
+			return float64(av.Value) == bv.Value
+		}
+	case *BigIntValue:
+		if bn, ok := bigIntOf(b); ok {
+			return av.Value.Cmp(bn) == 0
+		}
+		if bd, ok := b.(*DecimalValue); ok {
+			return bd.Value.IsInt() && bd.Value.Num().Cmp(av.Value) == 0
+		}
+	case *DecimalValue:
+		if bd, ok := decimalOf(b); ok {
+			return av.Value.Cmp(bd) == 0
 		}
 	case *FloatValue:
-		if bv, ok := b.(*FloatValue); ok {
+		switch bv := b.(type) {
+		case *FloatValue:
 			return av.Value == bv.Value
+		case *IntegerValue:
+			return av.Value == float64(bv.Value)
 		}
 	case *StringValue:
 		if bv, ok := b.(*StringValue); ok {
@@ -355,6 +1969,140 @@ func valuesEqual(a, b Value) bool {
 	case *NullValue:
 		_, ok := b.(*NullValue)
 		return ok
+	case *BytesValue:
+		if bv, ok := b.(*BytesValue); ok {
+			return bytes.Equal(av.Value, bv.Value)
+		}
+	case *ListValue:
+		if bv, ok := b.(*ListValue); ok {
+			if len(av.Elements) != len(bv.Elements) {
+				return false
+			}
+			for i := range av.Elements {
+				if !valuesEqual(av.Elements[i], bv.Elements[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	case *TupleValue:
+		if bv, ok := b.(*TupleValue); ok {
+			return valuesEqual(&ListValue{Elements: av.Elements}, &ListValue{Elements: bv.Elements})
+		}
+	case *MapValue:
+		if bv, ok := b.(*MapValue); ok {
+			return fieldsEqual(av.Pairs, bv.Pairs)
+		}
+	case *StructValue:
+		if bv, ok := b.(*StructValue); ok {
+			return av.Definition.Name == bv.Definition.Name && fieldsEqual(av.Fields, bv.Fields)
+		}
+	case *EnumValue:
+		if bv, ok := b.(*EnumValue); ok {
+			return av.Enum == bv.Enum && av.Variant == bv.Variant &&
+				valuesEqual(&ListValue{Elements: av.Values}, &ListValue{Elements: bv.Values})
+		}
+	case *OptionValue:
+		if bv, ok := b.(*OptionValue); ok {
+			if av.IsSome != bv.IsSome {
+				return false
+			}
+			return !av.IsSome || valuesEqual(av.Value, bv.Value)
+		}
+	case *ResultValue:
+		if bv, ok := b.(*ResultValue); ok {
+			if av.IsOk != bv.IsOk {
+				return false
+			}
+			if av.IsOk {
+				return valuesEqual(av.Value, bv.Value)
+			}
+			return av.Error.Message == bv.Error.Message
+		}
 	}
 	return false
 }
+
+func fieldsEqual(a, b map[string]Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !valuesEqual(av, bv) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashKey returns a canonical string for a value such that values that are
+// equal according to valuesEqual produce the same key
+func hashKey(v Value) string {
+	switch val := UnwrapValue(v).(type) {
+	case *IntegerValue:
+		return "i" + strconv.FormatInt(val.Value, 10)
+	case *BigIntValue:
+		// A BigInt equal to an Integer hashes like it
+		return "i" + val.Value.String()
+	case *DecimalValue:
+		if val.Value.IsInt() {
+			return "i" + val.Value.Num().String()
+		}
+		return "d" + val.Value.RatString()
+	case *FloatValue:
+		// Whole floats hash like the equal integer, since 3 is 3.0
+		if val.Value == math.Trunc(val.Value) && math.Abs(val.Value) < 1<<63 {
+			return "i" + strconv.FormatInt(int64(val.Value), 10)
+		}
+		return "f" + strconv.FormatFloat(val.Value, 'g', -1, 64)
+	case *StringValue:
+		return "s" + strconv.Quote(val.Value)
+	case *BooleanValue:
+		return "b" + strconv.FormatBool(val.Value)
+	case *NullValue:
+		return "null"
+	case *BytesValue:
+		return "x" + hex.EncodeToString(val.Value)
+	case *ListValue:
+		return "[" + elementsHashKey(val.Elements) + "]"
+	case *TupleValue:
+		return "(" + elementsHashKey(val.Elements) + ")"
+	case *MapValue:
+		return "{" + fieldsHashKey(val.Pairs) + "}"
+	case *StructValue:
+		return val.Definition.Name + "{" + fieldsHashKey(val.Fields) + "}"
+	case *EnumValue:
+		return val.Enum + "." + val.Variant + "(" + elementsHashKey(val.Values) + ")"
+	case *OptionValue:
+		if !val.IsSome {
+			return "None"
+		}
+		return "Some(" + hashKey(val.Value) + ")"
+	case *ResultValue:
+		if !val.IsOk {
+			return "Error(" + strconv.Quote(val.Error.Message) + ")"
+		}
+		return "Ok(" + hashKey(val.Value) + ")"
+	default:
+		// Functions and other reference values are only equal to themselves
+		return fmt.Sprintf("%s@%p", val.Type(), val)
+	}
+}
+
+func elementsHashKey(elements []Value) string {
+	parts := make([]string, len(elements))
+	for i, elem := range elements {
+		parts[i] = hashKey(elem)
+	}
+	return strings.Join(parts, ",")
+}
+
+func fieldsHashKey(fields map[string]Value) string {
+	keys := sortedKeys(fields)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = strconv.Quote(k) + ":" + hashKey(fields[k])
+	}
+	return strings.Join(parts, ",")
+}
@@ -2,14 +2,28 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // TypeChecker performs static type checking
 type TypeChecker struct {
-	env       *TypeEnvironment
-	structs   map[string]*StructType
-	functions map[string]*FunctionType
-	errors    []string
+	env        *TypeEnvironment
+	builtins   *TypeEnvironment // parent of the program's top-level scope
+	structs    map[string]*StructType
+	functions  map[string]*FunctionType
+	extensions map[string]map[string]*FunctionType // type name -> method name -> type
+	interfaces map[string]*InterfaceType
+	enums      map[string]*EnumType
+	aliases    map[string]Type
+	loops      []string // labels of enclosing loops, "" for unlabeled ones
+	inFunction bool     // whether return is allowed
+	errors     []string
+	warnings   []string
+	pos        Token // token of the node being checked, for diagnostics
+
+	// WarnShadowing reports defs that hide a name from an enclosing scope
+	WarnShadowing bool
 }
 
 // TypeEnvironment stores type bindings
@@ -42,6 +56,12 @@ func (e *TypeEnvironment) Get(name string) (Type, bool) {
 	return t, ok
 }
 
+// GetLocal retrieves a type defined in this environment, ignoring parents
+func (e *TypeEnvironment) GetLocal(name string) (Type, bool) {
+	t, ok := e.store[name]
+	return t, ok
+}
+
 // Set defines a new type binding
 func (e *TypeEnvironment) Set(name string, t Type) {
 	e.store[name] = t
@@ -50,26 +70,112 @@ func (e *TypeEnvironment) Set(name string, t Type) {
 // NewTypeChecker creates a new type checker
 func NewTypeChecker() *TypeChecker {
 	tc := &TypeChecker{
-		env:       NewTypeEnvironment(),
-		structs:   make(map[string]*StructType),
-		functions: make(map[string]*FunctionType),
+		env:        NewTypeEnvironment(),
+		structs:    make(map[string]*StructType),
+		functions:  make(map[string]*FunctionType),
+		extensions: make(map[string]map[string]*FunctionType),
+		interfaces: make(map[string]*InterfaceType),
+		enums:      make(map[string]*EnumType),
+		aliases:    make(map[string]Type),
 	}
 
 	// Register built-in function types
 	tc.env.Set("print", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &NullType{}})
 	tc.env.Set("println", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &NullType{}})
+	tc.env.Set("printParts", &FunctionType{Parameters: []Type{&ListType{Element: &AnyType{}}, &StringType{}}, Return: &NullType{}})
+	tc.env.Set("printlnParts", &FunctionType{Parameters: []Type{&ListType{Element: &AnyType{}}, &StringType{}}, Return: &NullType{}})
+	tc.env.Set("eprint", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &NullType{}})
+	tc.env.Set("eprintln", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &NullType{}})
+	tc.env.Set("assertEqual", &FunctionType{Parameters: []Type{&AnyType{}, &AnyType{}}, Return: &NullType{}})
+	tc.env.Set("assertTrue", &FunctionType{Parameters: []Type{&BooleanType{}}, Return: &NullType{}})
+	tc.env.Set("log", &FunctionType{Parameters: []Type{&StringType{}, &AnyType{}, &MapType{Key: &StringType{}, Value: &AnyType{}}}, Return: &NullType{}})
+	tc.env.Set("readAll", &FunctionType{Parameters: []Type{}, Return: &StringType{}})
 	tc.env.Set("range", &FunctionType{Parameters: []Type{&IntegerType{}, &IntegerType{}}, Return: &ListType{Element: &IntegerType{}}})
 	tc.env.Set("len", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &IntegerType{}})
 	tc.env.Set("type", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &StringType{}})
 	tc.env.Set("str", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &StringType{}})
 	tc.env.Set("int", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &IntegerType{}})
+	tc.env.Set("bigint", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &BigIntType{}})
+	tc.env.Set("decimal", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &DecimalType{}})
 	tc.env.Set("float", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &FloatType{}})
+	tc.env.Set("parseInt", &FunctionType{Parameters: []Type{&StringType{}}, Return: &ResultType{ValueType: &IntegerType{}, ErrorType: &StringType{}}})
+	tc.env.Set("parseFloat", &FunctionType{Parameters: []Type{&StringType{}}, Return: &ResultType{ValueType: &FloatType{}, ErrorType: &StringType{}}})
+	tc.env.Set("ord", &FunctionType{Parameters: []Type{&StringType{}}, Return: &IntegerType{}})
+	tc.env.Set("char", &FunctionType{Parameters: []Type{&IntegerType{}}, Return: &StringType{}})
+	tc.env.Set("bytes", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &BytesType{}})
+	tc.env.Set("base64Encode", &FunctionType{Parameters: []Type{&BytesType{}}, Return: &StringType{}})
+	tc.env.Set("base64Decode", &FunctionType{Parameters: []Type{&StringType{}}, Return: &ResultType{ValueType: &BytesType{}, ErrorType: &StringType{}}})
+	tc.env.Set("hexEncode", &FunctionType{Parameters: []Type{&BytesType{}}, Return: &StringType{}})
+	tc.env.Set("hexDecode", &FunctionType{Parameters: []Type{&StringType{}}, Return: &ResultType{ValueType: &BytesType{}, ErrorType: &StringType{}}})
+	tc.env.Set("formatNumber", &FunctionType{Parameters: []Type{&AnyType{}, &IntegerType{}}, Return: &StringType{}})
+	tc.env.Set("repeat", &FunctionType{Parameters: []Type{&TypeVariable{Name: "T"}, &IntegerType{}}, Return: &ListType{Element: &TypeVariable{Name: "T"}}})
+	tc.env.Set("fill", &FunctionType{Parameters: []Type{&IntegerType{}, &AnyType{}}, Return: &ListType{Element: &AnyType{}}})
+	tc.env.Set("memoize", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &AnyType{}})
+	tc.env.Set("compose", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &AnyType{}})
+	copyType := &FunctionType{Parameters: []Type{&TypeVariable{Name: "T"}}, Return: &TypeVariable{Name: "T"}}
+	tc.env.Set("copy", copyType)
+	tc.env.Set("clone", copyType)
+	tc.env.Set("hash", &FunctionType{Parameters: []Type{&AnyType{}}, Return: &IntegerType{}})
+	tc.env.Set("toOption", &FunctionType{Parameters: []Type{&TypeVariable{Name: "T"}}, Return: &OptionType{Element: &TypeVariable{Name: "T"}}})
+	tc.env.Set("toResult", &FunctionType{Parameters: []Type{&AnyType{}, &StringType{}}, Return: &ResultType{ValueType: &AnyType{}, ErrorType: &StringType{}}})
+	tc.env.Set("exit", &FunctionType{Parameters: []Type{&IntegerType{}}, Return: &NullType{}})
+	tc.env.Set("env", &FunctionType{Parameters: []Type{&StringType{}}, Return: &OptionType{Element: &StringType{}}})
+	tc.env.Set("envOr", &FunctionType{Parameters: []Type{&StringType{}, &StringType{}}, Return: &StringType{}})
+
+	// Builtins live in their own scope so a program may reuse their names
+	tc.builtins = tc.env
+	tc.env = NewEnclosedTypeEnvironment(tc.builtins)
 
 	return tc
 }
 
+// RegisterBuiltinType declares the type of a host-provided builtin
+func (tc *TypeChecker) RegisterBuiltinType(name string, t Type) {
+	tc.builtins.Set(name, t)
+}
+
+// registerHostFunctions declares the types of all host functions in opts
+func (tc *TypeChecker) registerHostFunctions(opts Options) {
+	for name, host := range opts.Builtins {
+		if host.Type != nil {
+			tc.RegisterBuiltinType(name, host.Type)
+		} else {
+			tc.RegisterBuiltinType(name, &FunctionType{Parameters: []Type{&AnyType{}}, Return: &AnyType{}})
+		}
+	}
+}
+
 // Check performs type checking on a program
 func (tc *TypeChecker) Check(program *Program) error {
+	// Collect type aliases first so any annotation can use them
+	for _, stmt := range program.Statements {
+		if alias, ok := stmt.(*TypeAliasStatement); ok {
+			tc.aliases[alias.Name.Value] = tc.typeFromAnnotation(alias.Value)
+		}
+	}
+
+	// Declare interfaces and enums before reading their members, so a method
+	// or variant can refer to its own type
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *InterfaceStatement:
+			tc.interfaces[s.Name.Value] = &InterfaceType{Name: s.Name.Value, Methods: make(map[string]*FunctionType)}
+		case *EnumStatement:
+			tc.enums[s.Name.Value] = &EnumType{Name: s.Name.Value, Payloads: make(map[string][]Type)}
+			tc.env.Set(s.Name.Value, tc.enums[s.Name.Value])
+		}
+	}
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *InterfaceStatement:
+			for _, method := range s.Methods {
+				tc.interfaces[s.Name.Value].Methods[method.Name.Value] = tc.functionType(method)
+			}
+		case *EnumStatement:
+			tc.collectEnum(s)
+		}
+	}
+
 	// First pass: collect struct and function definitions
 	for _, stmt := range program.Statements {
 		switch s := stmt.(type) {
@@ -93,32 +199,82 @@ func (tc *TypeChecker) Check(program *Program) error {
 	return nil
 }
 
+// typeFromAnnotation converts an annotation to a Type, expanding type aliases
+func (tc *TypeChecker) typeFromAnnotation(ta *TypeAnnotation) Type {
+	return transformType(TypeFromAnnotation(ta), func(t Type) Type {
+		if st, ok := t.(*StructType); ok {
+			if alias, ok := tc.aliases[st.Name]; ok {
+				return alias
+			}
+			if iface, ok := tc.interfaces[st.Name]; ok {
+				return iface
+			}
+			if enum, ok := tc.enums[st.Name]; ok {
+				return enum
+			}
+		}
+		return t
+	})
+}
+
 func (tc *TypeChecker) collectExtend(stmt *ExtendStatement) {
+	methods, ok := tc.extensions[stmt.TypeName.Value]
+	if !ok {
+		methods = make(map[string]*FunctionType)
+		tc.extensions[stmt.TypeName.Value] = methods
+	}
+	// Methods are keyed by type, so two types can each have their own plus
 	for _, method := range stmt.Methods {
-		tc.collectFunction(method)
+		methods[method.Name.Value] = tc.functionType(method)
+	}
+}
+
+func (tc *TypeChecker) collectEnum(stmt *EnumStatement) {
+	enum := tc.enums[stmt.Name.Value]
+	for _, variant := range stmt.Variants {
+		name := variant.Name.Value
+		if _, ok := enum.Payloads[name]; ok {
+			restore := tc.at(variant.Name)
+			tc.addError(fmt.Sprintf("duplicate variant %s in enum %s", name, enum.Name))
+			restore()
+			continue
+		}
+		payload := make([]Type, len(variant.Fields))
+		for i, field := range variant.Fields {
+			payload[i] = tc.typeFromAnnotation(field)
+		}
+		enum.Variants = append(enum.Variants, name)
+		enum.Payloads[name] = payload
 	}
 }
 
 func (tc *TypeChecker) collectStruct(stmt *StructStatement) {
 	fields := make(map[string]Type)
 	for _, f := range stmt.Fields {
-		fields[f.Name.Value] = TypeFromAnnotation(f.TypeHint)
+		fields[f.Name.Value] = tc.typeFromAnnotation(f.TypeHint)
 	}
 	tc.structs[stmt.Name.Value] = &StructType{Name: stmt.Name.Value, Fields: fields}
 	tc.env.Set(stmt.Name.Value, tc.structs[stmt.Name.Value])
 }
 
 func (tc *TypeChecker) collectFunction(stmt *FunctionStatement) {
+	tc.functions[stmt.Name.Value] = tc.functionType(stmt)
+	tc.env.Set(stmt.Name.Value, tc.functions[stmt.Name.Value])
+}
+
+// functionType builds the type of a function from its annotations
+func (tc *TypeChecker) functionType(stmt *FunctionStatement) *FunctionType {
 	params := make([]Type, len(stmt.Parameters))
 	for i, p := range stmt.Parameters {
-		params[i] = TypeFromAnnotation(p.TypeHint)
+		params[i] = bindTypeParams(tc.typeFromAnnotation(p.TypeHint), stmt.TypeParams)
 	}
-	returnType := TypeFromAnnotation(stmt.ReturnType)
-	tc.functions[stmt.Name.Value] = &FunctionType{Parameters: params, Return: returnType}
-	tc.env.Set(stmt.Name.Value, tc.functions[stmt.Name.Value])
+	returnType := bindTypeParams(tc.typeFromAnnotation(stmt.ReturnType), stmt.TypeParams)
+	return &FunctionType{Parameters: params, Return: returnType}
 }
 
 func (tc *TypeChecker) checkStatement(stmt Statement) Type {
+	defer tc.at(stmt)()
+
 	switch s := stmt.(type) {
 	case *DefStatement:
 		return tc.checkDefStatement(s)
@@ -132,35 +288,164 @@ func (tc *TypeChecker) checkStatement(stmt Statement) Type {
 		return tc.checkWhileStatement(s)
 	case *ForStatement:
 		return tc.checkForStatement(s)
+	case *RepeatStatement:
+		return tc.checkRepeatStatement(s)
+	case *GuardStatement:
+		return tc.checkGuardStatement(s)
 	case *StructStatement:
 		return tc.structs[s.Name.Value]
+	case *TypeAliasStatement, *InterfaceStatement, *EnumStatement:
+		return &NullType{}
 	case *ExtendStatement:
 		return tc.checkExtendStatement(s)
 	case *ImportStatement:
+		return tc.checkImportStatement(s)
+	case *BreakStatement:
+		tc.checkLoopControl("break", s.Label)
 		return &NullType{}
-	case *BreakStatement, *ContinueStatement:
+	case *ContinueStatement:
+		tc.checkLoopControl("continue", s.Label)
 		return &NullType{}
 	}
 	return &AnyType{}
 }
 
+// checkImportStatement declares the imported module name. Built-in modules
+// have known member types; modules loaded from disk are not checked.
+func (tc *TypeChecker) checkImportStatement(stmt *ImportStatement) Type {
+	name := stmt.Path[0]
+	if members, ok := builtinModules[name]; ok {
+		tc.env.Set(name, builtinModuleType(name, members))
+	} else {
+		tc.env.Set(name, &AnyType{})
+	}
+	return &NullType{}
+}
+
+// checkLoopControl verifies that break/continue appear inside a loop and
+// that a label, if given, names an enclosing loop
+func (tc *TypeChecker) checkLoopControl(keyword string, label *Identifier) {
+	if len(tc.loops) == 0 {
+		tc.addError(fmt.Sprintf("%s outside loop", keyword))
+		return
+	}
+	if label == nil {
+		return
+	}
+	for _, l := range tc.loops {
+		if l == label.Value {
+			return
+		}
+	}
+	tc.addError(fmt.Sprintf("undefined label: %s", label.Value))
+}
+
+// enterLoop records a loop for break/continue checking; call the returned
+// function when leaving the loop body
+func (tc *TypeChecker) enterLoop(label *Identifier) func() {
+	tc.loops = append(tc.loops, labelName(label))
+	return func() { tc.loops = tc.loops[:len(tc.loops)-1] }
+}
+
+// enterFunction starts checking a function body, where return is allowed
+// and enclosing loops don't apply; call the returned function when leaving it
+func (tc *TypeChecker) enterFunction() func() {
+	prevLoops, prevInFunction := tc.loops, tc.inFunction
+	tc.loops, tc.inFunction = nil, true
+	return func() { tc.loops, tc.inFunction = prevLoops, prevInFunction }
+}
+
 func (tc *TypeChecker) checkDefStatement(stmt *DefStatement) Type {
-	valueType := tc.checkExpression(stmt.Value)
+	var valueType Type
+	if lit, ok := stmt.Value.(*ListLiteral); ok && stmt.TypeHint != nil {
+		valueType = tc.checkListLiteralAgainst(lit, tc.typeFromAnnotation(stmt.TypeHint))
+	} else {
+		valueType = tc.checkExpression(stmt.Value)
+	}
+
+	if stmt.Name == nil {
+		tc.checkDestructuringTargets(stmt.Targets, valueType)
+		return valueType
+	}
 
 	if stmt.TypeHint != nil {
-		expectedType := TypeFromAnnotation(stmt.TypeHint)
+		expectedType := tc.typeFromAnnotation(stmt.TypeHint)
 		if !tc.isAssignable(expectedType, valueType) {
-			tc.addError(fmt.Sprintf("cannot assign %s to variable of type %s",
-				valueType.String(), expectedType.String()))
+			tc.addError(fmt.Sprintf("cannot assign %s to variable of type %s%s",
+				valueType.String(), expectedType.String(), tc.explainMismatch(expectedType, valueType)))
 		}
-		tc.env.Set(stmt.Name.Value, expectedType)
+		tc.declare(stmt.Name, expectedType)
 		return expectedType
 	}
 
-	tc.env.Set(stmt.Name.Value, valueType)
+	tc.declare(stmt.Name, valueType)
 	return valueType
 }
 
+// declare binds a name introduced by def in the current scope. Names can't
+// be redefined in the same scope; updates go through Mutable and ==.
+func (tc *TypeChecker) declare(name *Identifier, t Type) {
+	defer tc.at(name)()
+
+	if _, local := tc.env.GetLocal(name.Value); local {
+		tc.addError(fmt.Sprintf("%s already defined", name.Value))
+		return
+	}
+	if tc.WarnShadowing && tc.definedByProgram(name.Value) {
+		tc.addWarning(name.Token, fmt.Sprintf("%s shadows a definition from an enclosing scope", name.Value))
+	}
+	if len(tc.loops) > 0 {
+		// A loop body gets a fresh scope each iteration, so this starts over
+		// every time instead of updating the outer Mutable
+		outer, ok := tc.env.Get(name.Value)
+		_, outerMutable := outer.(*MutableType)
+		_, mutable := t.(*MutableType)
+		if ok && outerMutable && mutable {
+			tc.addWarning(name.Token, fmt.Sprintf("%s inside a loop is a new Mutable each iteration and hides the outer one (use %s == ... to update it)",
+				name.Value, name.Value))
+		}
+	}
+	tc.env.Set(name.Value, t)
+}
+
+// definedByProgram reports whether name is bound in an enclosing scope of the
+// program, as opposed to being a builtin
+func (tc *TypeChecker) definedByProgram(name string) bool {
+	for env := tc.env; env != nil && env != tc.builtins; env = env.parent {
+		if _, ok := env.GetLocal(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TypeChecker) checkDestructuringTargets(targets []*Identifier, valueType Type) {
+	if mut, ok := valueType.(*MutableType); ok {
+		valueType = mut.Element
+	}
+
+	for i, target := range targets {
+		var elemType Type = &AnyType{}
+		switch t := valueType.(type) {
+		case *ListType:
+			elemType = t.Element
+		case *TupleType:
+			if len(t.Elements) != len(targets) {
+				tc.addError(fmt.Sprintf("cannot destructure %s into %d names", t.String(), len(targets)))
+				return
+			}
+			elemType = t.Elements[i]
+		case *AnyType:
+		default:
+			tc.addError(fmt.Sprintf("cannot destructure %s", valueType.String()))
+			return
+		}
+		if target.Value != "_" {
+			tc.declare(target, elemType)
+		}
+	}
+}
+
 func (tc *TypeChecker) checkFunctionStatement(stmt *FunctionStatement) Type {
 	fnType := tc.functions[stmt.Name.Value]
 
@@ -173,14 +458,19 @@ func (tc *TypeChecker) checkFunctionStatement(stmt *FunctionStatement) Type {
 		tc.env.Set(p.Name.Value, fnType.Parameters[i])
 	}
 
-	// Check function body
+	// Check function body; loops outside the function don't apply inside it
+	leaveFunction := tc.enterFunction()
 	tc.checkBlockStatement(stmt.Body, fnType.Return)
+	leaveFunction()
 
 	tc.env = prevEnv
 	return fnType
 }
 
 func (tc *TypeChecker) checkReturnStatement(stmt *ReturnStatement) Type {
+	if !tc.inFunction {
+		tc.addError("return outside function")
+	}
 	if stmt.Value == nil {
 		return &NullType{}
 	}
@@ -189,13 +479,45 @@ func (tc *TypeChecker) checkReturnStatement(stmt *ReturnStatement) Type {
 
 func (tc *TypeChecker) checkWhileStatement(stmt *WhileStatement) Type {
 	condType := tc.checkExpression(stmt.Condition)
-	if !tc.isBooleanCompatible(condType) {
-		tc.addError("while condition must be a boolean expression")
+	tc.checkCondition("while", condType)
+
+	prevEnv := tc.env
+	tc.env = NewEnclosedTypeEnvironment(prevEnv)
+	leaveLoop := tc.enterLoop(stmt.Label)
+	tc.checkBlockStatement(stmt.Body, nil)
+	leaveLoop()
+	tc.env = prevEnv
+
+	if stmt.Else != nil {
+		tc.env = NewEnclosedTypeEnvironment(prevEnv)
+		tc.checkBlockStatement(stmt.Else, nil)
+		tc.env = prevEnv
 	}
 
+	return &NullType{}
+}
+
+func (tc *TypeChecker) checkGuardStatement(stmt *GuardStatement) Type {
+	condType := tc.checkExpression(stmt.Condition)
+	tc.checkCondition("guard", condType)
+
 	prevEnv := tc.env
 	tc.env = NewEnclosedTypeEnvironment(prevEnv)
+	tc.checkBlockStatement(stmt.Else, nil)
+	tc.env = prevEnv
+
+	return &NullType{}
+}
+
+func (tc *TypeChecker) checkRepeatStatement(stmt *RepeatStatement) Type {
+	prevEnv := tc.env
+	tc.env = NewEnclosedTypeEnvironment(prevEnv)
+	leaveLoop := tc.enterLoop(stmt.Label)
 	tc.checkBlockStatement(stmt.Body, nil)
+	leaveLoop()
+
+	condType := tc.checkExpression(stmt.Condition)
+	tc.checkCondition("until", condType)
 	tc.env = prevEnv
 
 	return &NullType{}
@@ -204,16 +526,37 @@ func (tc *TypeChecker) checkWhileStatement(stmt *WhileStatement) Type {
 func (tc *TypeChecker) checkForStatement(stmt *ForStatement) Type {
 	iterType := tc.checkExpression(stmt.Iterable)
 
-	listType, ok := iterType.(*ListType)
-	if !ok {
+	// Unwrap mutable
+	if mut, ok := iterType.(*MutableType); ok {
+		iterType = mut.Element
+	}
+
+	var indexType, elemType Type
+	switch t := iterType.(type) {
+	case *ListType:
+		indexType, elemType = &IntegerType{}, t.Element
+	case *MapType:
+		if stmt.Index == nil {
+			tc.addError(fmt.Sprintf("cannot iterate over %s with a single variable, use for k, v in ...", iterType.String()))
+			return &NullType{}
+		}
+		indexType, elemType = t.Key, t.Value
+	case *AnyType:
+		indexType, elemType = &AnyType{}, &AnyType{}
+	default:
 		tc.addError(fmt.Sprintf("cannot iterate over %s", iterType.String()))
 		return &NullType{}
 	}
 
 	prevEnv := tc.env
 	tc.env = NewEnclosedTypeEnvironment(prevEnv)
-	tc.env.Set(stmt.Variable.Value, listType.Element)
+	if stmt.Index != nil {
+		tc.env.Set(stmt.Index.Value, indexType)
+	}
+	tc.env.Set(stmt.Variable.Value, elemType)
+	leaveLoop := tc.enterLoop(stmt.Label)
 	tc.checkBlockStatement(stmt.Body, nil)
+	leaveLoop()
 	tc.env = prevEnv
 
 	return &NullType{}
@@ -235,7 +578,7 @@ func (tc *TypeChecker) checkExtendStatement(stmt *ExtendStatement) Type {
 			tc.env.Set("this", &AnyType{})
 		}
 
-		fnType := tc.functions[method.Name.Value]
+		fnType := tc.extensions[typeName][method.Name.Value]
 		if fnType != nil {
 			// Add parameters to scope
 			for i, p := range method.Parameters {
@@ -245,7 +588,9 @@ func (tc *TypeChecker) checkExtendStatement(stmt *ExtendStatement) Type {
 			}
 
 			// Check function body
+			leaveFunction := tc.enterFunction()
 			tc.checkBlockStatement(method.Body, fnType.Return)
+			leaveFunction()
 		}
 
 		tc.env = prevEnv
@@ -259,11 +604,13 @@ func (tc *TypeChecker) checkBlockStatement(block *BlockStatement, expectedReturn
 		lastType = tc.checkStatement(stmt)
 
 		if ret, ok := stmt.(*ReturnStatement); ok && expectedReturn != nil {
+			restore := tc.at(ret)
 			retType := tc.checkExpression(ret.Value)
 			if !tc.isAssignable(expectedReturn, retType) {
 				tc.addError(fmt.Sprintf("cannot return %s from function expecting %s",
 					retType.String(), expectedReturn.String()))
 			}
+			restore()
 		}
 	}
 	return lastType
@@ -273,9 +620,13 @@ func (tc *TypeChecker) checkExpression(expr Expression) Type {
 	if expr == nil {
 		return &NullType{}
 	}
+	defer tc.at(expr)()
 
 	switch e := expr.(type) {
 	case *IntegerLiteral:
+		if e.Big != nil {
+			return &BigIntType{}
+		}
 		return &IntegerType{}
 	case *FloatLiteral:
 		return &FloatType{}
@@ -303,6 +654,8 @@ func (tc *TypeChecker) checkExpression(expr Expression) Type {
 		return tc.checkIndexExpression(e)
 	case *ListLiteral:
 		return tc.checkListLiteral(e)
+	case *TupleLiteral:
+		return tc.checkTupleLiteral(e)
 	case *MapLiteral:
 		return tc.checkMapLiteral(e)
 	case *StructLiteral:
@@ -353,6 +706,18 @@ func (tc *TypeChecker) checkInfixExpression(expr *InfixExpression) Type {
 
 	switch expr.Operator {
 	case "+", "-", "*", "/", "%":
+		if expr.Operator == "/" || expr.Operator == "%" {
+			if lit, ok := expr.Right.(*IntegerLiteral); ok && lit.Big == nil && lit.Value == 0 {
+				tc.addError(fmt.Sprintf("division by zero: %s", expr.String()))
+			}
+		}
+		operandType := leftType
+		if mut, ok := operandType.(*MutableType); ok {
+			operandType = mut.Element
+		}
+		if st, ok := operandType.(*StructType); ok {
+			return tc.checkOperatorMethod(expr.Operator, st, rightType)
+		}
 		if !tc.isNumeric(leftType) || !tc.isNumeric(rightType) {
 			// String concatenation
 			if expr.Operator == "+" && tc.isString(leftType) && tc.isString(rightType) {
@@ -361,6 +726,25 @@ func (tc *TypeChecker) checkInfixExpression(expr *InfixExpression) Type {
 			tc.addError(fmt.Sprintf("operator %s not defined for %s and %s",
 				expr.Operator, leftType.String(), rightType.String()))
 		}
+		// A Decimal or BigInt with an Integer keeps the exact type; with a
+		// Float, the result would lose the precision it is for
+		if isDecimal(leftType) || isDecimal(rightType) {
+			if isFloat(leftType) || isFloat(rightType) {
+				tc.addError(fmt.Sprintf("operator %s not defined for %s and %s (convert the Float with decimal(str(x)))",
+					expr.Operator, leftType.String(), rightType.String()))
+			}
+			if expr.Operator == "%" {
+				tc.addError(fmt.Sprintf("operator %% not defined for %s and %s", leftType.String(), rightType.String()))
+			}
+			return &DecimalType{}
+		}
+		if isBigInt(leftType) || isBigInt(rightType) {
+			if isFloat(leftType) || isFloat(rightType) {
+				tc.addError(fmt.Sprintf("operator %s not defined for %s and %s (convert the Float with int)",
+					expr.Operator, leftType.String(), rightType.String()))
+			}
+			return &BigIntType{}
+		}
 		// Return Float if either operand is Float
 		if _, ok := leftType.(*FloatType); ok {
 			return &FloatType{}
@@ -377,16 +761,54 @@ func (tc *TypeChecker) checkInfixExpression(expr *InfixExpression) Type {
 		}
 		return &BooleanType{}
 
-	case "and", "or":
+	case "and", "or", "xor":
 		return &BooleanType{}
 
+	case "??":
+		valueType := leftType
+		if mut, ok := valueType.(*MutableType); ok {
+			valueType = mut.Element
+		}
+		switch t := valueType.(type) {
+		case *OptionType:
+			valueType = t.Element
+		case *NullType:
+			return rightType
+		}
+		if _, ok := valueType.(*AnyType); ok {
+			return rightType
+		}
+		if !tc.isAssignable(valueType, rightType) {
+			tc.addError(fmt.Sprintf("cannot use %s as the default for %s", rightType.String(), leftType.String()))
+		}
+		return valueType
+
 	case "is":
+		if !tc.isEquatable(leftType, rightType) {
+			tc.addError(fmt.Sprintf("cannot compare %s and %s with is",
+				leftType.String(), rightType.String()))
+		}
 		return &BooleanType{}
 	}
 
 	return &AnyType{}
 }
 
+// checkOperatorMethod types an arithmetic operator on a struct, which calls
+// the extension method named by operatorMethods
+func (tc *TypeChecker) checkOperatorMethod(op string, st *StructType, rightType Type) Type {
+	name := operatorMethods[op]
+	fn, ok := tc.extensions[st.Name][name]
+	if !ok {
+		tc.addError(fmt.Sprintf("operator %s not defined for %s (extend %s with a %s method)", op, st.Name, st.Name, name))
+		return &AnyType{}
+	}
+	if len(fn.Parameters) == 1 && !tc.isAssignable(fn.Parameters[0], rightType) {
+		tc.addError(fmt.Sprintf("cannot pass %s to %s.%s", rightType.String(), st.Name, name))
+	}
+	return fn.Return
+}
+
 func (tc *TypeChecker) checkAssignmentExpression(expr *AssignmentExpression) Type {
 	varType, ok := tc.env.Get(expr.Name.Value)
 	if !ok {
@@ -396,7 +818,7 @@ func (tc *TypeChecker) checkAssignmentExpression(expr *AssignmentExpression) Typ
 
 	mutType, isMutable := varType.(*MutableType)
 	if !isMutable {
-		tc.addError(fmt.Sprintf("%s is not mutable", expr.Name.Value))
+		tc.addError(fmt.Sprintf("cannot reassign %s: use Mutable to allow reassignment", expr.Name.Value))
 		return &AnyType{}
 	}
 
@@ -411,17 +833,23 @@ func (tc *TypeChecker) checkAssignmentExpression(expr *AssignmentExpression) Typ
 
 func (tc *TypeChecker) checkIfExpression(expr *IfExpression) Type {
 	condType := tc.checkExpression(expr.Condition)
-	if !tc.isBooleanCompatible(condType) {
-		tc.addError("if condition must be a boolean expression")
-	}
+	tc.checkCondition("if", condType)
+
+	name, narrowed, rest := tc.typeGuard(expr.Condition)
 
 	prevEnv := tc.env
 	tc.env = NewEnclosedTypeEnvironment(prevEnv)
+	if narrowed != nil {
+		tc.env.Set(name, narrowed)
+	}
 	consType := tc.checkBlockStatement(expr.Consequence, nil)
 	tc.env = prevEnv
 
 	if expr.Alternative != nil {
 		tc.env = NewEnclosedTypeEnvironment(prevEnv)
+		if rest != nil {
+			tc.env.Set(name, rest)
+		}
 		altType := tc.checkBlockStatement(expr.Alternative, nil)
 		tc.env = prevEnv
 
@@ -447,7 +875,35 @@ func (tc *TypeChecker) checkFunctionLiteral(expr *FunctionLiteral) Type {
 }
 
 func (tc *TypeChecker) checkCallExpression(expr *CallExpression) Type {
-	fnType := tc.checkExpression(expr.Function)
+	t := tc.callType(expr)
+	if member, ok := expr.Function.(*MemberExpression); ok && member.Optional {
+		return optionalOf(t)
+	}
+	return t
+}
+
+func (tc *TypeChecker) callType(expr *CallExpression) Type {
+	var fnType Type
+	if member, ok := expr.Function.(*MemberExpression); ok {
+		objType := tc.checkExpression(member.Object)
+		if member.Optional {
+			objType = optionalInner(objType)
+		}
+		if t := tc.checkMutableMethodCall(objType, member.Member.Value, expr.Arguments); t != nil {
+			return t
+		}
+		if t := tc.checkListMethodCall(objType, member.Member.Value, expr.Arguments); t != nil {
+			return t
+		}
+		if enum, ok := objType.(*EnumType); ok {
+			if ident, ok := member.Object.(*Identifier); ok && ident.Value == enum.Name {
+				return tc.checkVariantCall(enum, member.Member.Value, expr.Arguments)
+			}
+		}
+		fnType = tc.memberType(objType, member)
+	} else {
+		fnType = tc.checkExpression(expr.Function)
+	}
 
 	// If it's Any (e.g., a method call we can't resolve), just check args and return Any
 	if _, ok := fnType.(*AnyType); ok {
@@ -481,22 +937,79 @@ func (tc *TypeChecker) checkCallExpression(expr *CallExpression) Type {
 		}
 	}
 
-	// Check argument types
+	// Check argument types, working out what any type parameters stand for
+	bindings := make(map[string]Type)
 	for i, arg := range expr.Arguments {
 		argType := tc.checkExpression(arg)
 		if i < len(fn.Parameters) {
+			if name := unifyTypes(fn.Parameters[i], argType, bindings); name != "" {
+				tc.addError(fmt.Sprintf("conflicting types for type parameter %s: %s and %s",
+					name, bindings[name].String(), argType.String()))
+			}
 			if !tc.isAssignable(fn.Parameters[i], argType) {
-				// Skip strict type checking for now - too many false positives
+				// Skip strict type checking for now - too many false positives,
+				// except for unions and interfaces, which exist to say exactly
+				// what is accepted
+				switch fn.Parameters[i].(type) {
+				case *UnionType, *InterfaceType:
+					tc.addError(fmt.Sprintf("cannot pass %s as %s%s", argType.String(), fn.Parameters[i].String(),
+						tc.explainMismatch(fn.Parameters[i], argType)))
+				}
 			}
 		}
 	}
 
-	return fn.Return
+	return substituteTypeVariables(fn.Return, bindings)
+}
+
+// checkVariantCall checks the payload passed to an enum variant such as
+// Shape.Circle(2.0) against the types the variant declares
+func (tc *TypeChecker) checkVariantCall(enum *EnumType, variant string, args []Expression) Type {
+	payload, ok := enum.Payloads[variant]
+	if !ok {
+		tc.addError(fmt.Sprintf("undefined variant %s in enum %s", variant, enum.Name))
+		return &AnyType{}
+	}
+	if len(args) != len(payload) {
+		tc.addError(fmt.Sprintf("%s.%s takes %d values, got %d", enum.Name, variant, len(payload), len(args)))
+	}
+	for i, arg := range args {
+		argType := tc.checkExpression(arg)
+		if i < len(payload) && !tc.isAssignable(payload[i], argType) {
+			tc.addError(fmt.Sprintf("cannot pass %s as %s to %s.%s", argType.String(), payload[i].String(), enum.Name, variant))
+		}
+	}
+	return enum
 }
 
 func (tc *TypeChecker) checkMemberExpression(expr *MemberExpression) Type {
 	objType := tc.checkExpression(expr.Object)
+	if expr.Optional {
+		return optionalOf(tc.memberType(optionalInner(objType), expr))
+	}
+	return tc.memberType(objType, expr)
+}
+
+// optionalInner returns the type ?. looks into: T for Option[T], or t itself
+func optionalInner(t Type) Type {
+	if mut, ok := t.(*MutableType); ok {
+		t = mut.Element
+	}
+	if opt, ok := t.(*OptionType); ok {
+		return opt.Element
+	}
+	return t
+}
+
+// optionalOf returns the type of a ?. step that produces t
+func optionalOf(t Type) Type {
+	if opt, ok := t.(*OptionType); ok {
+		return opt
+	}
+	return &OptionType{Element: t}
+}
 
+func (tc *TypeChecker) memberType(objType Type, expr *MemberExpression) Type {
 	// Unwrap mutable
 	if mut, ok := objType.(*MutableType); ok {
 		objType = mut.Element
@@ -510,10 +1023,169 @@ func (tc *TypeChecker) checkMemberExpression(expr *MemberExpression) Type {
 		return &AnyType{}
 	}
 
+	if iface, ok := objType.(*InterfaceType); ok {
+		if method, ok := iface.Methods[expr.Member.Value]; ok {
+			return method
+		}
+		return &AnyType{}
+	}
+
+	// Color.Red is a variant; Shape.Circle builds one from its payload
+	if enum, ok := objType.(*EnumType); ok {
+		if ident, ok := expr.Object.(*Identifier); ok && ident.Value == enum.Name {
+			payload, ok := enum.Payloads[expr.Member.Value]
+			if !ok {
+				tc.addError(fmt.Sprintf("undefined variant %s in enum %s", expr.Member.Value, enum.Name))
+				return &AnyType{}
+			}
+			if len(payload) == 0 {
+				return enum
+			}
+			return &FunctionType{Parameters: payload, Return: enum}
+		}
+		// Could be an extension method
+		return &AnyType{}
+	}
+
 	// Could be a method call on a list, map, etc.
 	return &AnyType{}
 }
 
+// checkMutableMethodCall types inc, dec and set on a Mutable receiver,
+// returning the element type. It returns nil for other calls.
+func (tc *TypeChecker) checkMutableMethodCall(objType Type, method string, args []Expression) Type {
+	mut, ok := objType.(*MutableType)
+	if !ok {
+		return nil
+	}
+
+	switch method {
+	case "inc", "dec":
+		if !tc.isNumeric(mut.Element) {
+			tc.addError(fmt.Sprintf("%s() requires a mutable number, got Mutable[%s]", method, mut.Element.String()))
+		}
+		for _, arg := range args {
+			tc.checkExpression(arg)
+		}
+		return mut.Element
+	case "set":
+		if len(args) != 1 {
+			tc.addError("set() requires 1 argument")
+			return mut.Element
+		}
+		argType := tc.checkExpression(args[0])
+		if !tc.isAssignable(mut.Element, argType) {
+			tc.addError(fmt.Sprintf("cannot assign %s to Mutable[%s]", argType.String(), mut.Element.String()))
+		}
+		return mut.Element
+	case "push":
+		list, ok := mut.Element.(*ListType)
+		if !ok {
+			return nil
+		}
+		if len(args) != 1 {
+			tc.addError("push() requires 1 argument")
+			return list
+		}
+		argType := tc.checkExpression(args[0])
+		if !tc.isAssignable(list.Element, argType) {
+			tc.addError(fmt.Sprintf("cannot push %s onto %s", argType.String(), list.String()))
+		}
+		return list
+	}
+	return nil
+}
+
+// checkListMethodCall types the higher-order list methods, checking lambda
+// arguments against the element type. It returns nil for other calls.
+func (tc *TypeChecker) checkListMethodCall(objType Type, method string, args []Expression) Type {
+	if mut, ok := objType.(*MutableType); ok {
+		objType = mut.Element
+	}
+	list, ok := objType.(*ListType)
+	if !ok {
+		return nil
+	}
+	elem := list.Element
+
+	switch {
+	case method == "push":
+		tc.addError("push() requires a Mutable list; use append() to get a new list")
+		return list
+	case method == "map" && len(args) == 1:
+		return &ListType{Element: tc.checkLambdaArgument(args[0], elem)}
+	case method == "filter" && len(args) == 1:
+		tc.checkLambdaArgument(args[0], elem)
+		return &ListType{Element: elem}
+	case method == "sort":
+		return &ListType{Element: elem}
+	case method == "min" || method == "max":
+		return &OptionType{Element: elem}
+	case (method == "find" || method == "maxBy" || method == "minBy") && len(args) == 1:
+		tc.checkLambdaArgument(args[0], elem)
+		return &OptionType{Element: elem}
+	case method == "partition" && len(args) == 1:
+		tc.checkLambdaArgument(args[0], elem)
+		return &ListType{Element: &ListType{Element: elem}}
+	case method == "groupBy" && len(args) == 1:
+		tc.checkLambdaArgument(args[0], elem)
+		return &MapType{Key: &StringType{}, Value: &ListType{Element: elem}}
+	case method == "zipWith" && len(args) == 2:
+		var otherElem Type = &AnyType{}
+		switch other := tc.checkExpression(args[0]).(type) {
+		case *ListType:
+			otherElem = other.Element
+		case *AnyType:
+		default:
+			tc.addError(fmt.Sprintf("zipWith() first argument must be a list, got %s", other.String()))
+		}
+		return &ListType{Element: tc.checkLambdaArgument(args[1], elem, otherElem)}
+	case method == "toMap" && len(args) == 0:
+		switch pair := elem.(type) {
+		case *ListType:
+			return &MapType{Key: &StringType{}, Value: pair.Element}
+		case *TupleType:
+			if len(pair.Elements) == 2 {
+				return &MapType{Key: &StringType{}, Value: pair.Elements[1]}
+			}
+		}
+		return &MapType{Key: &StringType{}, Value: &AnyType{}}
+	case method == "reduce" && len(args) == 2:
+		accType := tc.checkExpression(args[1])
+		tc.checkLambdaArgument(args[0], accType, elem)
+		return accType
+	}
+	return nil
+}
+
+// checkLambdaArgument checks a lambda passed to a higher-order method with its
+// parameters bound to the given types, returning the type of its body.
+// Arguments that aren't lambda literals are checked as plain expressions.
+func (tc *TypeChecker) checkLambdaArgument(arg Expression, paramTypes ...Type) Type {
+	lambda, ok := arg.(*FunctionLiteral)
+	if !ok {
+		tc.checkExpression(arg)
+		return &AnyType{}
+	}
+
+	prevEnv := tc.env
+	tc.env = NewEnclosedTypeEnvironment(prevEnv)
+	for i, p := range lambda.Parameters {
+		var t Type = &AnyType{}
+		if i < len(paramTypes) {
+			t = paramTypes[i]
+		}
+		tc.env.Set(p.Value, t)
+	}
+
+	leaveFunction := tc.enterFunction()
+	bodyType := tc.checkExpression(lambda.Body)
+	leaveFunction()
+
+	tc.env = prevEnv
+	return bodyType
+}
+
 func (tc *TypeChecker) checkIndexExpression(expr *IndexExpression) Type {
 	leftType := tc.checkExpression(expr.Left)
 	indexType := tc.checkExpression(expr.Index)
@@ -529,6 +1201,19 @@ func (tc *TypeChecker) checkIndexExpression(expr *IndexExpression) Type {
 			tc.addError("list index must be an integer")
 		}
 		return t.Element
+	case *TupleType:
+		if !tc.isInteger(indexType) {
+			tc.addError("tuple index must be an integer")
+		}
+		// A literal index selects the precise element type
+		if lit, ok := expr.Index.(*IntegerLiteral); ok {
+			if lit.Value < 0 || lit.Value >= int64(len(t.Elements)) {
+				tc.addError(fmt.Sprintf("tuple index %d out of range for %s", lit.Value, t.String()))
+				return &AnyType{}
+			}
+			return t.Elements[lit.Value]
+		}
+		return &AnyType{}
 	case *MapType:
 		if !tc.isString(indexType) {
 			tc.addError("map key must be a string")
@@ -567,16 +1252,39 @@ func (tc *TypeChecker) checkListLiteral(expr *ListLiteral) Type {
 	return &ListType{Element: elemType}
 }
 
+// checkListLiteralAgainst checks each element of a list literal against the
+// element type of an annotated list, so [Circle {...}, Square {...}] can
+// be a List[Shape]. Other expected types fall back to checkListLiteral.
+func (tc *TypeChecker) checkListLiteralAgainst(expr *ListLiteral, expected Type) Type {
+	list, ok := expected.(*ListType)
+	if !ok || len(expr.Elements) == 0 {
+		return tc.checkListLiteral(expr)
+	}
+	for _, elem := range expr.Elements {
+		t := tc.checkExpression(elem)
+		if !tc.isAssignable(list.Element, t) {
+			tc.addError(fmt.Sprintf("cannot use %s as %s in list%s",
+				t.String(), list.Element.String(), tc.explainMismatch(list.Element, t)))
+		}
+	}
+	return list
+}
+
+func (tc *TypeChecker) checkTupleLiteral(expr *TupleLiteral) Type {
+	elements := make([]Type, len(expr.Elements))
+	for i, elem := range expr.Elements {
+		elements[i] = tc.checkExpression(elem)
+	}
+	return &TupleType{Elements: elements}
+}
+
 func (tc *TypeChecker) checkMapLiteral(expr *MapLiteral) Type {
 	if len(expr.Pairs) == 0 {
 		return &MapType{Key: &StringType{}, Value: &AnyType{}}
 	}
 
-	var valueType Type = &AnyType{}
-	for _, v := range expr.Pairs {
-		valueType = tc.checkExpression(v)
-		break // Just check first value for now
-	}
+	// Just check first value for now
+	valueType := tc.checkExpression(expr.Pairs[0].Value)
 
 	return &MapType{Key: &StringType{}, Value: valueType}
 }
@@ -588,6 +1296,22 @@ func (tc *TypeChecker) checkStructLiteral(expr *StructLiteral) Type {
 		return &AnyType{}
 	}
 
+	for _, spread := range expr.Spreads {
+		spreadType := tc.checkExpression(spread)
+		if mut, ok := spreadType.(*MutableType); ok {
+			spreadType = mut.Element
+		}
+		switch t := spreadType.(type) {
+		case *AnyType:
+		case *StructType:
+			if t.Name != st.Name {
+				tc.addError(fmt.Sprintf("cannot spread %s into %s", t.Name, st.Name))
+			}
+		default:
+			tc.addError(fmt.Sprintf("cannot spread %s into %s", spreadType.String(), st.Name))
+		}
+	}
+
 	for fieldName, fieldExpr := range expr.Fields {
 		expectedType, ok := st.Fields[fieldName]
 		if !ok {
@@ -619,9 +1343,8 @@ func (tc *TypeChecker) checkWithExpression(expr *WithExpression) Type {
 	}
 
 	for fieldName, fieldExpr := range expr.Updates {
-		expectedType, ok := st.Fields[fieldName]
+		expectedType, ok := tc.fieldPathType(st, fieldName)
 		if !ok {
-			tc.addError(fmt.Sprintf("undefined field %s on %s", fieldName, st.Name))
 			continue
 		}
 		actualType := tc.checkExpression(fieldExpr)
@@ -634,6 +1357,33 @@ func (tc *TypeChecker) checkWithExpression(expr *WithExpression) Type {
 	return st
 }
 
+// fieldPathType resolves a field name, or a dotted path like "server.port"
+// through nested structs, to the type of the field it names
+func (tc *TypeChecker) fieldPathType(st *StructType, path string) (Type, bool) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fieldType, ok := st.Fields[name]
+		if !ok {
+			tc.addError(fmt.Sprintf("undefined field %s on %s", name, st.Name))
+			return nil, false
+		}
+		if i == len(names)-1 {
+			return fieldType, true
+		}
+
+		nested, ok := fieldType.(*StructType)
+		if ok {
+			nested, ok = tc.structs[nested.Name]
+		}
+		if !ok {
+			tc.addError(fmt.Sprintf("field %s of %s is not a struct", name, st.Name))
+			return nil, false
+		}
+		st = nested
+	}
+	return nil, false
+}
+
 func (tc *TypeChecker) checkOptionExpression(expr *OptionExpression) Type {
 	if !expr.IsSome {
 		return &OptionType{Element: &AnyType{}}
@@ -651,28 +1401,121 @@ func (tc *TypeChecker) checkResultExpression(expr *ResultExpression) Type {
 }
 
 func (tc *TypeChecker) checkMatchExpression(expr *MatchExpression) Type {
-	tc.checkExpression(expr.Value)
+	valueType := tc.checkExpression(expr.Value)
 
 	var resultType Type = &NullType{}
 	for _, c := range expr.Cases {
 		prevEnv := tc.env
 		tc.env = NewEnclosedTypeEnvironment(prevEnv)
 
-		if c.BindingVar != nil {
-			tc.env.Set(c.BindingVar.Value, &AnyType{})
-		}
+		tc.bindPattern(c.Pattern, valueType)
 
 		resultType = tc.checkBlockStatement(c.Body, nil)
 		tc.env = prevEnv
 	}
 
+	if mut, ok := valueType.(*MutableType); ok {
+		valueType = mut.Element
+	}
+	if enum, ok := valueType.(*EnumType); ok {
+		tc.checkEnumCoverage(enum, expr.Cases)
+	}
+
 	return resultType
 }
 
+// checkEnumCoverage reports enum variants no case matches. A variant counts
+// as covered when a case names it with only bindings for its payload, and a
+// bare binding such as _ covers every variant.
+func (tc *TypeChecker) checkEnumCoverage(enum *EnumType, cases []*MatchCase) {
+	covered := make(map[string]bool)
+	for _, c := range cases {
+		for _, pattern := range append([]Expression{c.Pattern}, c.Alternatives...) {
+			if _, ok := pattern.(*Identifier); ok {
+				return
+			}
+			name, variant, payload, ok := splitEnumPattern(pattern)
+			if !ok || name != enum.Name {
+				continue
+			}
+			irrefutable := true
+			for _, p := range payload {
+				if _, ok := p.(*Identifier); !ok {
+					irrefutable = false
+				}
+			}
+			if irrefutable {
+				covered[variant] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, variant := range enum.Variants {
+		if !covered[variant] {
+			missing = append(missing, variant)
+		}
+	}
+	if len(missing) > 0 {
+		tc.addError(fmt.Sprintf("match on %s is not exhaustive: missing %s", enum.Name, strings.Join(missing, ", ")))
+	}
+}
+
+// bindPattern declares the names a match pattern binds in the current scope
+func (tc *TypeChecker) bindPattern(pattern Expression, valueType Type) {
+	if binding := patternBinding(pattern); binding != nil {
+		tc.env.Set(binding.Value, &AnyType{})
+		return
+	}
+
+	switch pat := pattern.(type) {
+	case *Identifier:
+		if pat.Value != "_" {
+			tc.env.Set(pat.Value, valueType)
+		}
+	case *MemberExpression, *CallExpression:
+		name, variant, payload, ok := splitEnumPattern(pat)
+		if !ok {
+			return
+		}
+		enum, ok := tc.enums[name]
+		if !ok {
+			tc.addError(fmt.Sprintf("undefined enum: %s", name))
+			return
+		}
+		types, ok := enum.Payloads[variant]
+		if !ok {
+			tc.addError(fmt.Sprintf("undefined variant %s in enum %s", variant, name))
+			return
+		}
+		if len(payload) != len(types) {
+			tc.addError(fmt.Sprintf("pattern %s.%s has %d values, want %d", name, variant, len(payload), len(types)))
+			return
+		}
+		for i, p := range payload {
+			tc.bindPattern(p, types[i])
+		}
+	case *StructLiteral:
+		st, ok := tc.structs[pat.StructName.Value]
+		if !ok {
+			tc.addError(fmt.Sprintf("undefined struct: %s", pat.StructName.Value))
+			return
+		}
+		for name, fieldPattern := range pat.Fields {
+			fieldType, ok := st.Fields[name]
+			if !ok {
+				tc.addError(fmt.Sprintf("undefined field %s on %s", name, st.Name))
+				continue
+			}
+			tc.bindPattern(fieldPattern, fieldType)
+		}
+	}
+}
+
 func (tc *TypeChecker) checkMutableExpression(expr *MutableExpression) Type {
 	elemType := tc.checkExpression(expr.Value)
 	if expr.TypeHint != nil {
-		elemType = TypeFromAnnotation(expr.TypeHint)
+		elemType = tc.typeFromAnnotation(expr.TypeHint)
 	}
 	return &MutableType{Element: elemType}
 }
@@ -699,6 +1542,52 @@ func (tc *TypeChecker) isAssignable(expected, actual Type) bool {
 		}
 	}
 
+	// A union accepts a value of any member type; a union value fits only
+	// where every member does
+	if actUnion, ok := actual.(*UnionType); ok {
+		for _, m := range actUnion.Members {
+			if !tc.isAssignable(expected, m) {
+				return false
+			}
+		}
+		return true
+	}
+	if expUnion, ok := expected.(*UnionType); ok {
+		for _, m := range expUnion.Members {
+			if tc.isAssignable(m, actual) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Handle List and Map types element-wise, so List[Any] fits List[Integer]
+	if expList, ok := expected.(*ListType); ok {
+		if actList, ok := actual.(*ListType); ok {
+			return tc.isAssignable(expList.Element, actList.Element)
+		}
+	}
+	if expMap, ok := expected.(*MapType); ok {
+		if actMap, ok := actual.(*MapType); ok {
+			return tc.isAssignable(expMap.Key, actMap.Key) && tc.isAssignable(expMap.Value, actMap.Value)
+		}
+	}
+
+	// Handle Tuple types element-wise
+	if expTup, ok := expected.(*TupleType); ok {
+		if actTup, ok := actual.(*TupleType); ok {
+			if len(expTup.Elements) != len(actTup.Elements) {
+				return false
+			}
+			for i := range expTup.Elements {
+				if !tc.isAssignable(expTup.Elements[i], actTup.Elements[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
 	// Handle Result types - be lenient with element types involving Any
 	if expRes, ok := expected.(*ResultType); ok {
 		if actRes, ok := actual.(*ResultType); ok {
@@ -706,9 +1595,63 @@ func (tc *TypeChecker) isAssignable(expected, actual Type) bool {
 		}
 	}
 
+	// A struct fits an interface when its extension methods provide every
+	// method the interface declares
+	if iface, ok := expected.(*InterfaceType); ok {
+		if st, ok := actual.(*StructType); ok {
+			return tc.missingMethod(st, iface) == ""
+		}
+	}
+
 	return expected.Equals(actual)
 }
 
+// missingMethod describes the first interface method st lacks or declares
+// with the wrong type, or returns "" when st satisfies iface
+func (tc *TypeChecker) missingMethod(st *StructType, iface *InterfaceType) string {
+	names := make([]string, 0, len(iface.Methods))
+	for name := range iface.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want := iface.Methods[name]
+		got, ok := tc.extensions[st.Name][name]
+		if !ok {
+			return "missing method " + name
+		}
+		matches := len(got.Parameters) == len(want.Parameters) && tc.isAssignable(want.Return, got.Return)
+		for i := 0; matches && i < len(want.Parameters); i++ {
+			matches = tc.isAssignable(got.Parameters[i], want.Parameters[i])
+		}
+		if !matches {
+			return fmt.Sprintf("method %s has type %s, want %s", name, got.String(), want.String())
+		}
+	}
+	return ""
+}
+
+// explainMismatch gives the reason a struct doesn't satisfy an expected
+// interface, formatted to follow a "cannot assign" style error
+func (tc *TypeChecker) explainMismatch(expected, actual Type) string {
+	if mut, ok := actual.(*MutableType); ok {
+		actual = mut.Element
+	}
+	iface, ok := expected.(*InterfaceType)
+	if !ok {
+		return ""
+	}
+	st, ok := actual.(*StructType)
+	if !ok {
+		return ""
+	}
+	if reason := tc.missingMethod(st, iface); reason != "" {
+		return fmt.Sprintf(" (%s does not implement %s: %s)", st.Name, iface.Name, reason)
+	}
+	return ""
+}
+
 func (tc *TypeChecker) isNumeric(t Type) bool {
 	if _, ok := t.(*AnyType); ok {
 		return true
@@ -716,9 +1659,38 @@ func (tc *TypeChecker) isNumeric(t Type) bool {
 	if mut, ok := t.(*MutableType); ok {
 		return tc.isNumeric(mut.Element)
 	}
-	_, isInt := t.(*IntegerType)
-	_, isFloat := t.(*FloatType)
-	return isInt || isFloat
+	switch t.(type) {
+	case *IntegerType, *BigIntType, *DecimalType, *FloatType:
+		return true
+	}
+	return false
+}
+
+// isBigInt reports whether t is BigInt or Mutable[BigInt]
+func isBigInt(t Type) bool {
+	if mut, ok := t.(*MutableType); ok {
+		t = mut.Element
+	}
+	_, ok := t.(*BigIntType)
+	return ok
+}
+
+// isDecimal reports whether t is Decimal or Mutable[Decimal]
+func isDecimal(t Type) bool {
+	if mut, ok := t.(*MutableType); ok {
+		t = mut.Element
+	}
+	_, ok := t.(*DecimalType)
+	return ok
+}
+
+// isFloat reports whether t is Float or Mutable[Float]
+func isFloat(t Type) bool {
+	if mut, ok := t.(*MutableType); ok {
+		t = mut.Element
+	}
+	_, ok := t.(*FloatType)
+	return ok
 }
 
 func (tc *TypeChecker) isInteger(t Type) bool {
@@ -743,15 +1715,135 @@ func (tc *TypeChecker) isString(t Type) bool {
 	return ok
 }
 
-func (tc *TypeChecker) isBooleanCompatible(t Type) bool {
-	if _, ok := t.(*AnyType); ok {
-		return true
+// typeGuard recognizes a condition of the form type(x) is "Name" and returns
+// the type x has when it holds. For a union, rest is what x can be when it
+// doesn't. Mutable names aren't narrowed, since == can change their type.
+func (tc *TypeChecker) typeGuard(cond Expression) (name string, narrowed, rest Type) {
+	infix, ok := cond.(*InfixExpression)
+	if !ok || infix.Operator != "is" {
+		return "", nil, nil
+	}
+	call, ok := infix.Left.(*CallExpression)
+	lit, isLit := infix.Right.(*StringLiteral)
+	if !ok || !isLit {
+		call, ok = infix.Right.(*CallExpression)
+		lit, isLit = infix.Left.(*StringLiteral)
+		if !ok || !isLit {
+			return "", nil, nil
+		}
+	}
+	fn, ok := call.Function.(*Identifier)
+	if !ok || fn.Value != "type" || len(call.Arguments) != 1 {
+		return "", nil, nil
+	}
+	arg, ok := call.Arguments[0].(*Identifier)
+	if !ok {
+		return "", nil, nil
+	}
+	declared, ok := tc.env.Get(arg.Value)
+	if !ok {
+		return "", nil, nil
+	}
+
+	switch t := declared.(type) {
+	case *UnionType:
+		var matching, others []Type
+		for _, m := range t.Members {
+			if runtimeTypeName(m) == lit.Value {
+				matching = append(matching, m)
+			} else {
+				others = append(others, m)
+			}
+		}
+		if len(matching) == 0 {
+			return "", nil, nil
+		}
+		return arg.Value, unionOf(matching), unionOf(others)
+	case *AnyType:
+		if named := tc.typeNamed(lit.Value); named != nil {
+			return arg.Value, named, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// runtimeTypeName returns what type() reports for a value of type t
+func runtimeTypeName(t Type) string {
+	switch t := t.(type) {
+	case *ListType:
+		return "List"
+	case *TupleType:
+		return "Tuple"
+	case *MapType:
+		return "Map"
+	case *OptionType:
+		return "Option"
+	case *ResultType:
+		return "Result"
+	case *FunctionType:
+		return "Function"
+	case *StructType:
+		return t.Name
+	case *EnumType:
+		return t.Name
+	}
+	return t.String()
+}
+
+// typeNamed returns the type whose values type() reports as name, with any
+// element types left as Any
+func (tc *TypeChecker) typeNamed(name string) Type {
+	switch name {
+	case "Integer":
+		return &IntegerType{}
+	case "BigInt":
+		return &BigIntType{}
+	case "Decimal":
+		return &DecimalType{}
+	case "Float":
+		return &FloatType{}
+	case "String":
+		return &StringType{}
+	case "Boolean":
+		return &BooleanType{}
+	case "Bytes":
+		return &BytesType{}
+	case "List":
+		return &ListType{Element: &AnyType{}}
+	case "Map":
+		return &MapType{Key: &AnyType{}, Value: &AnyType{}}
+	}
+	if st, ok := tc.structs[name]; ok {
+		return st
+	}
+	if enum, ok := tc.enums[name]; ok {
+		return enum
+	}
+	return nil
+}
+
+func unionOf(members []Type) Type {
+	switch len(members) {
+	case 0:
+		return nil
+	case 1:
+		return members[0]
 	}
+	return &UnionType{Members: members}
+}
+
+// checkCondition checks the condition of an if, while, guard or until. Any
+// value can be tested for truth as IsTruthy does at runtime (0, "", empty
+// collections, None, Error and null are false), but a value that is always
+// true is almost certainly a mistake, such as a function that wasn't called.
+func (tc *TypeChecker) checkCondition(kind string, t Type) {
 	if mut, ok := t.(*MutableType); ok {
-		return tc.isBooleanCompatible(mut.Element)
+		t = mut.Element
+	}
+	switch t.(type) {
+	case *FunctionType, *StructType, *InterfaceType, *EnumType, *TupleType:
+		tc.addError(fmt.Sprintf("%s condition has type %s, which is always true", kind, t.String()))
 	}
-	_, ok := t.(*BooleanType)
-	return ok
 }
 
 func (tc *TypeChecker) isComparable(a, b Type) bool {
@@ -779,6 +1871,61 @@ func (tc *TypeChecker) isComparable(a, b Type) bool {
 	return false
 }
 
+// isEquatable reports whether `is` can ever be true for the two types.
+// Only mismatched primitives are rejected; numbers compare across Integer and Float.
+func (tc *TypeChecker) isEquatable(a, b Type) bool {
+	if mut, ok := a.(*MutableType); ok {
+		a = mut.Element
+	}
+	if mut, ok := b.(*MutableType); ok {
+		b = mut.Element
+	}
+
+	if tc.isNumeric(a) && tc.isNumeric(b) {
+		return true
+	}
+	if !isPrimitiveType(a) || !isPrimitiveType(b) {
+		return true
+	}
+	return a.Equals(b)
+}
+
+func isPrimitiveType(t Type) bool {
+	switch t.(type) {
+	case *IntegerType, *FloatType, *StringType, *BooleanType, *BytesType:
+		return true
+	}
+	return false
+}
+
 func (tc *TypeChecker) addError(msg string) {
-	tc.errors = append(tc.errors, msg)
+	tc.errors = append(tc.errors, diagnostic(tc.pos, msg))
+}
+
+// addWarning records a warning about the node at tok
+func (tc *TypeChecker) addWarning(tok Token, msg string) {
+	tc.warnings = append(tc.warnings, diagnostic(tok, msg))
+}
+
+// diagnostic prefixes msg with the position of tok in the same
+// "Line N, Column M: " form as parse errors
+func diagnostic(tok Token, msg string) string {
+	err := NewTypeError(msg)
+	err.Line, err.Column = tok.Line, tok.Column
+	return err.Error()
+}
+
+// at makes node the position reported by diagnostics; the returned function
+// restores the previous one
+func (tc *TypeChecker) at(node Node) func() {
+	outer := tc.pos
+	if tok, ok := nodeToken(node); ok {
+		tc.pos = tok
+	}
+	return func() { tc.pos = outer }
+}
+
+// Warnings returns the non-fatal problems found by Check
+func (tc *TypeChecker) Warnings() []string {
+	return tc.warnings
 }
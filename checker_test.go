@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// check type checks source with shadowing warnings on and returns the error
+// text ("" when the program is well typed) and any warnings
+func check(t *testing.T, source string) (string, []string) {
+	t.Helper()
+	parser := NewParser(NewLexer(source))
+	program := parser.ParseProgram()
+	if errs := parser.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %s", strings.Join(errs, "\n"))
+	}
+	tc := NewTypeChecker()
+	tc.WarnShadowing = true
+	if err := tc.Check(program); err != nil {
+		return err.Error(), tc.Warnings()
+	}
+	return "", tc.Warnings()
+}
+
+func TestCheckerAccepts(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+	}{
+		{"union type", "def x: Integer | String = \"a\""},
+		{"type alias", "type Id = Integer\ndef id: Id = 3"},
+		{"lambda return inferred", "def xs: List[Integer] = [1, 2].map({ n -> n * 2 })"},
+		{"type guard narrows", `
+fun f(x: Integer | String) -> Integer {
+    if type(x) is "Integer" {
+        return x.abs()
+    }
+    return 0
+}`},
+		{"condition may be any value", "if 1 {\n    println(\"x\")\n}"},
+		{"bigint arithmetic", "def b = bigint(2) * 3"},
+		{"decimal arithmetic", "def d = decimal(\"1.5\") + 2"},
+		{"coalesce unwraps option", "def n: Integer = Some(1) ?? 2"},
+		{"def reuses a builtin name", "def len = 3\ndef log = len + 1\ndef env = \"prod\"\ndef char = \"a\""},
+		{"fun reuses a builtin name", "fun log(x: Integer) -> Integer {\n    return x\n}\ndef y = log(1)"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err, _ := check(t, tc.source); err != "" {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckerRejects(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"division by literal zero", "def x = 1 / 0", "division by zero"},
+		{"modulo by literal zero", "def x = 1 % 0", "division by zero"},
+		{"redefinition", "def a = 1\ndef a = 2", "a already defined"},
+		{"reassigning a def", "def a = 1\na == 2", "cannot reassign a"},
+		{"function condition", "fun f() -> Integer {\n    return 1\n}\nif f {\n    println(1)\n}", "always true"},
+		{"decimal and float", "def d = decimal(\"1\") + 1.5", "Decimal"},
+		{"bigint and float", "def b = bigint(1) + 1.5", "BigInt"},
+		{"break outside loop", "break", "break outside loop"},
+		{"lambda body using the element type", `def xs = [1, 2].map({ n -> n + "a" })`, "operator + not defined for Integer and String"},
+		{"lambda result type", `def ys: List[String] = [1, 2].map({ n -> n * 2 })`, "cannot assign List[Integer] to variable of type List[String]"},
+		{"variant payload type", "enum Shape {\n    Circle(Float)\n}\ndef s = Shape.Circle(\"x\")", "cannot pass String as Float to Shape.Circle"},
+		{"variant payload count", "enum Shape {\n    Rect(Float, Float)\n}\ndef s = Shape.Rect(1.0)", "Shape.Rect takes 2 values, got 1"},
+		{"coalesce default of another type", "def n = Some(1) ?? \"x\"", "cannot use String as the default"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err, _ := check(t, tc.source)
+			if !strings.Contains(err, tc.want) {
+				t.Errorf("error %q does not mention %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestShadowingWarning(t *testing.T) {
+	_, warnings := check(t, `
+def name = "outer"
+fun f() -> String {
+    def name = "inner"
+    return name
+}`)
+	want := "Line 4, Column 9: name shadows a definition from an enclosing scope"
+	if len(warnings) != 1 || warnings[0] != want {
+		t.Fatalf("warnings = %q, want %q", warnings, want)
+	}
+
+	// A builtin is not an enclosing definition
+	if _, warnings := check(t, "def len = 3"); len(warnings) != 0 {
+		t.Fatalf("warnings = %q, want none for a builtin name", warnings)
+	}
+}
+
+func TestLoopMutableWarningWithShadowing(t *testing.T) {
+	_, warnings := check(t, `
+def total = Mutable[Integer](0)
+for n in [1, 2] {
+    def total = Mutable[Integer](n)
+}`)
+	want := []string{
+		"Line 4, Column 9: total shadows a definition from an enclosing scope",
+		"Line 4, Column 9: total inside a loop is a new Mutable each iteration and hides the outer one (use total == ... to update it)",
+	}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("warnings = %q, want %q", warnings, want)
+	}
+}
+
+func TestCheckerErrorPositions(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"def", "def x = 1\ndef y: Integer = \"a\"", "Line 2, Column 1: cannot assign String to variable of type Integer"},
+		{"redefinition", "def a = 1\ndef a = 2", "Line 2, Column 5: a already defined"},
+		{"operator", "def y = 1 + \"a\"", "Line 1, Column 11: operator + not defined for Integer and String"},
+		{"return", "fun f() -> Integer {\n    return \"s\"\n}", "Line 2, Column 5: cannot return String from function expecting Integer"},
+		{"duplicate variant", "enum E {\n    V\n    V\n}", "Line 3, Column 5: duplicate variant V in enum E"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err, _ := check(t, tc.source); err != tc.want {
+				t.Errorf("error %q, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoopMutableWarningPosition(t *testing.T) {
+	parser := NewParser(NewLexer("def n = Mutable(0)\nwhile n < 3 {\n    def n = Mutable(1)\n}"))
+	tc := NewTypeChecker()
+	tc.Check(parser.ParseProgram())
+	warnings := tc.Warnings()
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Line 3, Column 9: n inside a loop") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	const shapes = `
+interface Shape {
+    fun area() -> Float
+}
+struct Sq {
+    side: Float
+}
+struct Line {
+    len: Float
+}
+extend Sq {
+    fun area() -> Float {
+        return this.side * this.side
+    }
+}
+`
+	cases := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"conforming struct", "def shapes: List[Shape] = [Sq { side: 1.0 }]", ""},
+		{"missing method", "def s: Shape = Line { len: 1.0 }", "Line does not implement Shape: missing method area"},
+		{"wrong signature", "extend Line {\n    fun area() -> String {\n        return \"x\"\n    }\n}\ndef s: Shape = Line { len: 1.0 }",
+			"Line does not implement Shape: method area has type () -> String, want () -> Float"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err, _ := check(t, shapes+tc.source)
+			if tc.want == "" && err != "" || !strings.Contains(err, tc.want) {
+				t.Errorf("error %q, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -63,15 +63,19 @@ func EnrichError(err *ErrorValue, method string, input Value) *ErrorValue {
 	return err
 }
 
-// FormatError formats an error for display
+// FormatError formats an error for display, starting with its line if known
 func FormatError(err *ErrorValue) string {
+	var result string
+	if err.Line > 0 {
+		result = fmt.Sprintf("Line %d: ", err.Line)
+	}
 	if err.Method != "" {
-		result := fmt.Sprintf("Error in %s", err.Method)
+		result += fmt.Sprintf("Error in %s", err.Method)
 		if err.Input != "" {
 			result += fmt.Sprintf("\nInput: %s", err.Input)
 		}
 		result += fmt.Sprintf("\nReason: %s", err.Message)
 		return result
 	}
-	return err.Message
+	return result + err.Message
 }
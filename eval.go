@@ -2,29 +2,122 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
 )
 
+// Options controls what evaluated code is allowed to do
+type Options struct {
+	AllowImports bool // load modules from disk via import
+	MaxSteps     int  // abort after this many evaluated nodes, 0 means unlimited
+	MaxDepth     int  // maximum function call depth, 0 means defaultMaxDepth
+
+	WarnShadowing bool // print a warning when a def hides an outer name
+
+	// Quiet returns parse and type errors in the result instead of printing
+	// them (and any warnings) to stderr
+	Quiet bool
+
+	// Trace, when set, receives a line naming each node as it is evaluated
+	Trace io.Writer
+
+	// Builtins are host functions made available to the program
+	Builtins map[string]HostFunction
+}
+
+// defaultMaxDepth keeps deep recursion well below the Go stack limit
+const defaultMaxDepth = 10000
+
+// DefaultOptions returns the unrestricted options used by the CLI
+func DefaultOptions() Options {
+	return Options{AllowImports: true}
+}
+
 // Evaluator evaluates AST nodes
 type Evaluator struct {
 	structs    map[string]*StructDefinition
 	extensions map[string]map[string]*FunctionValue
 	modules    map[string]*ModuleValue
+	importing  []string // modules being evaluated, outermost first, to catch cycles
 	loader     *ModuleLoader
-	currentFn  string // current function name for error context
+	options    Options
+	steps      int          // nodes evaluated so far, checked against options.MaxSteps
+	depth      int          // current function call depth
+	currentFn  string       // current function name for error context
+	tests      *TestResults // set by RunTests; failed assertions are recorded here
+}
+
+// TestResults collects the outcome of assertEqual/assertTrue in test mode
+type TestResults struct {
+	Passed   int
+	Failures []*ErrorValue
+	line     int // line of the call being evaluated, for failure reports
+}
+
+// assertion records a passed or failed assertion. Outside test mode a failure
+// is returned as an error and stops the program.
+func (e *Evaluator) assertion(failure *ErrorValue) Value {
+	if e.tests == nil {
+		if failure != nil {
+			return failure
+		}
+		return &NullValue{}
+	}
+	if failure == nil {
+		e.tests.Passed++
+	} else {
+		failure.Line = e.tests.line
+		e.tests.Failures = append(e.tests.Failures, failure)
+	}
+	return &NullValue{}
 }
 
-// NewEvaluator creates a new Evaluator
+// NewEvaluator creates a new Evaluator with default options
 func NewEvaluator() *Evaluator {
+	return NewEvaluatorWithOptions(DefaultOptions())
+}
+
+// NewEvaluatorWithOptions creates a new Evaluator with the given options
+func NewEvaluatorWithOptions(opts Options) *Evaluator {
 	return &Evaluator{
 		structs:    make(map[string]*StructDefinition),
 		extensions: make(map[string]map[string]*FunctionValue),
 		modules:    make(map[string]*ModuleValue),
 		loader:     NewModuleLoader(),
+		options:    opts,
+	}
+}
+
+// enterCall increments the call depth, failing once the limit is exceeded.
+// Callers must decrement e.depth when the call returns.
+func (e *Evaluator) enterCall() *ErrorValue {
+	limit := e.options.MaxDepth
+	if limit <= 0 {
+		limit = defaultMaxDepth
 	}
+	if e.depth >= limit {
+		return &ErrorValue{Message: "maximum recursion depth exceeded"}
+	}
+	e.depth++
+	return nil
 }
 
 // Eval evaluates an AST node
 func (e *Evaluator) Eval(node Node, env *Environment) Value {
+	if e.options.Trace != nil {
+		e.traceNode(node)
+	}
+	if e.options.MaxSteps > 0 {
+		e.steps++
+		if e.steps > e.options.MaxSteps {
+			return &ErrorValue{Message: "execution limit exceeded"}
+		}
+	}
+
 	switch node := node.(type) {
 	// Statements
 	case *Program:
@@ -43,12 +136,21 @@ func (e *Evaluator) Eval(node Node, env *Environment) Value {
 		return e.evalWhileStatement(node, env)
 	case *ForStatement:
 		return e.evalForStatement(node, env)
+	case *RepeatStatement:
+		return e.evalRepeatStatement(node, env)
+	case *GuardStatement:
+		return e.evalGuardStatement(node, env)
 	case *BreakStatement:
-		return &BreakValue{}
+		return &BreakValue{Label: labelName(node.Label)}
 	case *ContinueStatement:
-		return &ContinueValue{}
+		return &ContinueValue{Label: labelName(node.Label)}
 	case *StructStatement:
 		return e.evalStructStatement(node, env)
+	case *EnumStatement:
+		return e.evalEnumStatement(node, env)
+	case *TypeAliasStatement, *InterfaceStatement:
+		// Aliases and interfaces only matter to the type checker
+		return &NullValue{}
 	case *ExtendStatement:
 		return e.evalExtendStatement(node, env)
 	case *ImportStatement:
@@ -56,6 +158,9 @@ func (e *Evaluator) Eval(node Node, env *Environment) Value {
 
 	// Expressions
 	case *IntegerLiteral:
+		if node.Big != nil {
+			return &BigIntValue{Value: node.Big}
+		}
 		return &IntegerValue{Value: node.Value}
 	case *FloatLiteral:
 		return &FloatValue{Value: node.Value}
@@ -83,6 +188,8 @@ func (e *Evaluator) Eval(node Node, env *Environment) Value {
 		return e.evalIndexExpression(node, env)
 	case *ListLiteral:
 		return e.evalListLiteral(node, env)
+	case *TupleLiteral:
+		return e.evalTupleLiteral(node, env)
 	case *MapLiteral:
 		return e.evalMapLiteral(node, env)
 	case *StructLiteral:
@@ -106,12 +213,12 @@ func (e *Evaluator) evalProgram(program *Program, env *Environment) Value {
 	var result Value = &NullValue{}
 
 	for _, stmt := range program.Statements {
-		result = e.Eval(stmt, env)
+		result = locateError(e.Eval(stmt, env), stmt)
 
 		switch result := result.(type) {
 		case *ReturnValue:
 			return result.Value
-		case *ErrorValue:
+		case *ErrorValue, *ExitValue:
 			return result
 		}
 	}
@@ -120,9 +227,51 @@ func (e *Evaluator) evalProgram(program *Program, env *Environment) Value {
 }
 
 func (e *Evaluator) evalDefStatement(stmt *DefStatement, env *Environment) Value {
+	if stmt.Name == nil {
+		return e.evalDestructuringDef(stmt, env)
+	}
+
 	val := e.Eval(stmt.Value, env)
-	// Note: ErrorValue is a valid value to assign, so don't propagate it as an error
+	switch val.(type) {
+	case *ExitValue, *ReturnValue, *BreakValue, *ContinueValue:
+		return val
+	}
+	// Note: ErrorValue is a valid value to assign, so don't propagate it as an error;
+	// it can be recovered later with .catch()
 	env.Set(stmt.Name.Value, val)
+	if isError(val) {
+		return &NullValue{}
+	}
+	return val
+}
+
+func (e *Evaluator) evalDestructuringDef(stmt *DefStatement, env *Environment) Value {
+	val := e.Eval(stmt.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	var elements []Value
+	switch v := UnwrapValue(val).(type) {
+	case *ListValue:
+		elements = v.Elements
+	case *TupleValue:
+		elements = v.Elements
+	default:
+		return &ErrorValue{Message: fmt.Sprintf("cannot destructure %s", val.Type())}
+	}
+
+	if len(elements) != len(stmt.Targets) {
+		return &ErrorValue{Message: fmt.Sprintf("cannot destructure %d values into %d names",
+			len(elements), len(stmt.Targets))}
+	}
+
+	for i, target := range stmt.Targets {
+		if target.Value == "_" {
+			continue
+		}
+		env.Set(target.Value, elements[i])
+	}
 	return val
 }
 
@@ -141,11 +290,11 @@ func (e *Evaluator) evalBlockStatement(block *BlockStatement, env *Environment)
 	var result Value = &NullValue{}
 
 	for _, stmt := range block.Statements {
-		result = e.Eval(stmt, env)
+		result = locateError(e.Eval(stmt, env), stmt)
 
 		if result != nil {
 			switch result.(type) {
-			case *ReturnValue, *BreakValue, *ContinueValue:
+			case *ReturnValue, *BreakValue, *ContinueValue, *ExitValue:
 				return result
 			}
 		}
@@ -165,6 +314,12 @@ func (e *Evaluator) evalFunctionStatement(stmt *FunctionStatement, env *Environm
 	return fn
 }
 
+// ownsLabel reports whether a loop labeled label handles a break/continue
+// targeting target. An unlabeled break/continue targets the innermost loop.
+func ownsLabel(label *Identifier, target string) bool {
+	return target == "" || (label != nil && label.Value == target)
+}
+
 func (e *Evaluator) evalWhileStatement(stmt *WhileStatement, env *Environment) Value {
 	for {
 		condition := e.Eval(stmt.Condition, env)
@@ -178,14 +333,74 @@ func (e *Evaluator) evalWhileStatement(stmt *WhileStatement, env *Environment) V
 
 		result := e.Eval(stmt.Body, NewEnclosedEnvironment(env))
 
-		switch result.(type) {
+		switch r := result.(type) {
 		case *BreakValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
 			return &NullValue{}
 		case *ContinueValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
 			continue
-		case *ReturnValue, *ErrorValue:
+		case *ReturnValue, *ErrorValue, *ExitValue:
+			return result
+		}
+	}
+
+	// The loop ended because its condition became false
+	if stmt.Else != nil {
+		return e.Eval(stmt.Else, NewEnclosedEnvironment(env))
+	}
+
+	return &NullValue{}
+}
+
+// evalGuardStatement runs the else block (which typically returns) when
+// the condition is falsy, and otherwise does nothing
+func (e *Evaluator) evalGuardStatement(stmt *GuardStatement, env *Environment) Value {
+	condition := e.Eval(stmt.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	if IsTruthy(condition) {
+		return &NullValue{}
+	}
+
+	return e.Eval(stmt.Else, NewEnclosedEnvironment(env))
+}
+
+// evalRepeatStatement runs the body at least once, stopping when the
+// condition becomes true. The condition can see the body's definitions.
+func (e *Evaluator) evalRepeatStatement(stmt *RepeatStatement, env *Environment) Value {
+	for {
+		loopEnv := NewEnclosedEnvironment(env)
+		result := e.Eval(stmt.Body, loopEnv)
+
+		switch r := result.(type) {
+		case *BreakValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
+			return &NullValue{}
+		case *ContinueValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
+		case *ReturnValue, *ErrorValue, *ExitValue:
 			return result
 		}
+
+		condition := e.Eval(stmt.Condition, loopEnv)
+		if isError(condition) {
+			return condition
+		}
+
+		if IsTruthy(condition) {
+			break
+		}
 	}
 
 	return &NullValue{}
@@ -197,23 +412,64 @@ func (e *Evaluator) evalForStatement(stmt *ForStatement, env *Environment) Value
 		return iterable
 	}
 
+	if m, ok := UnwrapValue(iterable).(*MapValue); ok && stmt.Index != nil {
+		return e.evalForMap(stmt, m, env)
+	}
+
 	list, ok := UnwrapValue(iterable).(*ListValue)
 	if !ok {
 		return &ErrorValue{Message: fmt.Sprintf("cannot iterate over %s", iterable.Type())}
 	}
 
-	for _, elem := range list.Elements {
+	for i, elem := range list.Elements {
 		loopEnv := NewEnclosedEnvironment(env)
+		if stmt.Index != nil {
+			loopEnv.Set(stmt.Index.Value, &IntegerValue{Value: int64(i)})
+		}
 		loopEnv.Set(stmt.Variable.Value, elem)
 
 		result := e.Eval(stmt.Body, loopEnv)
 
-		switch result.(type) {
+		switch r := result.(type) {
 		case *BreakValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
 			return &NullValue{}
 		case *ContinueValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
 			continue
-		case *ReturnValue, *ErrorValue:
+		case *ReturnValue, *ErrorValue, *ExitValue:
+			return result
+		}
+	}
+
+	return &NullValue{}
+}
+
+// evalForMap iterates a map in sorted key order, binding key and value
+func (e *Evaluator) evalForMap(stmt *ForStatement, m *MapValue, env *Environment) Value {
+	for _, k := range sortedKeys(m.Pairs) {
+		loopEnv := NewEnclosedEnvironment(env)
+		loopEnv.Set(stmt.Index.Value, &StringValue{Value: k})
+		loopEnv.Set(stmt.Variable.Value, m.Pairs[k])
+
+		result := e.Eval(stmt.Body, loopEnv)
+
+		switch r := result.(type) {
+		case *BreakValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
+			return &NullValue{}
+		case *ContinueValue:
+			if !ownsLabel(stmt.Label, r.Label) {
+				return r
+			}
+			continue
+		case *ReturnValue, *ErrorValue, *ExitValue:
 			return result
 		}
 	}
@@ -231,6 +487,49 @@ func (e *Evaluator) evalStructStatement(stmt *StructStatement, env *Environment)
 	return def
 }
 
+func (e *Evaluator) evalEnumStatement(stmt *EnumStatement, env *Environment) Value {
+	def := &EnumDefinition{Name: stmt.Name.Value, Variants: make(map[string]int)}
+	for _, variant := range stmt.Variants {
+		def.Variants[variant.Name.Value] = len(variant.Fields)
+	}
+	env.Set(stmt.Name.Value, def)
+	return def
+}
+
+// enumVariant evaluates Color.Red to the variant itself, and Shape.Circle
+// to a function that builds the variant from its payload
+func enumVariant(def *EnumDefinition, variant string) Value {
+	arity, ok := def.Variants[variant]
+	if !ok {
+		return &ErrorValue{Message: fmt.Sprintf("undefined variant %s in enum %s", variant, def.Name)}
+	}
+	if arity == 0 {
+		return &EnumValue{Enum: def.Name, Variant: variant}
+	}
+	return &BuiltinFunction{
+		Name: def.Name + "." + variant,
+		Fn: func(args ...Value) Value {
+			return newEnumValue(def, variant, args)
+		},
+	}
+}
+
+// newEnumValue builds a variant of def from its payload values
+func newEnumValue(def *EnumDefinition, variant string, args []Value) Value {
+	arity, ok := def.Variants[variant]
+	if !ok {
+		return &ErrorValue{Message: fmt.Sprintf("undefined variant %s in enum %s", variant, def.Name)}
+	}
+	if len(args) != arity {
+		return &ErrorValue{Message: fmt.Sprintf("%s.%s takes %d values, got %d", def.Name, variant, arity, len(args))}
+	}
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = UnwrapValue(arg)
+	}
+	return &EnumValue{Enum: def.Name, Variant: variant, Values: values}
+}
+
 func (e *Evaluator) evalExtendStatement(stmt *ExtendStatement, env *Environment) Value {
 	typeName := stmt.TypeName.Value
 
@@ -254,20 +553,46 @@ func (e *Evaluator) evalExtendStatement(stmt *ExtendStatement, env *Environment)
 func (e *Evaluator) evalImportStatement(stmt *ImportStatement, env *Environment) Value {
 	moduleName := stmt.Path[0]
 
+	if members, ok := builtinModules[moduleName]; ok {
+		mod, cached := e.modules[moduleName]
+		if !cached {
+			mod = newBuiltinModule(moduleName, members)
+			e.modules[moduleName] = mod
+		}
+		env.Set(moduleName, mod)
+		return mod
+	}
+
+	if !e.options.AllowImports {
+		return &ErrorValue{Message: fmt.Sprintf("cannot import %s: imports disabled", moduleName)}
+	}
+
+	// Each module is evaluated once per evaluator; later imports share it
 	if mod, ok := e.modules[moduleName]; ok {
 		env.Set(moduleName, mod)
 		return mod
 	}
+	for i, name := range e.importing {
+		if name == moduleName {
+			cycle := append(append([]string{}, e.importing[i:]...), moduleName)
+			return &ErrorValue{Message: "import cycle: " + strings.Join(cycle, " -> ")}
+		}
+	}
 
 	program, err := e.loader.Load(moduleName)
 	if err != nil {
 		return &ErrorValue{Message: err.Error()}
 	}
 
-	modEnv := NewEnvironment()
-	RegisterBuiltins(modEnv)
+	// Builtins live in a parent scope so that the module's own scope holds
+	// only what it defines
+	builtins := NewEnvironment()
+	RegisterBuiltinsWithOptions(builtins, e.options)
+	modEnv := NewEnclosedEnvironment(builtins)
 
+	e.importing = append(e.importing, moduleName)
 	result := e.Eval(program, modEnv)
+	e.importing = e.importing[:len(e.importing)-1]
 	if isError(result) {
 		return result
 	}
@@ -310,7 +635,14 @@ func (e *Evaluator) evalPrefixExpression(node *PrefixExpression, env *Environmen
 func (e *Evaluator) evalMinusPrefixExpression(right Value) Value {
 	switch val := right.(type) {
 	case *IntegerValue:
+		if val.Value == math.MinInt64 {
+			return &ErrorValue{Message: fmt.Sprintf("integer overflow: -(%d)", val.Value)}
+		}
 		return &IntegerValue{Value: -val.Value}
+	case *BigIntValue:
+		return &BigIntValue{Value: new(big.Int).Neg(val.Value)}
+	case *DecimalValue:
+		return &DecimalValue{Value: new(big.Rat).Neg(val.Value)}
 	case *FloatValue:
 		return &FloatValue{Value: -val.Value}
 	default:
@@ -322,12 +654,36 @@ func (e *Evaluator) evalNotPrefixExpression(right Value) Value {
 	return &BooleanValue{Value: !IsTruthy(right)}
 }
 
+// operatorMethods names the extension method that overloads each arithmetic
+// operator for structs: `a + b` calls `a.plus(b)`
+var operatorMethods = map[string]string{
+	"+": "plus",
+	"-": "minus",
+	"*": "times",
+	"/": "divide",
+	"%": "mod",
+}
+
 func (e *Evaluator) evalInfixExpression(node *InfixExpression, env *Environment) Value {
 	left := e.Eval(node.Left, env)
 	if isError(left) {
 		return left
 	}
 
+	// a ?? b unwraps Some(x) to x, and only evaluates b when a is None or null
+	if node.Operator == "??" {
+		switch v := UnwrapValue(left).(type) {
+		case *OptionValue:
+			if v.IsSome {
+				return v.Value
+			}
+		case *NullValue:
+		default:
+			return left
+		}
+		return e.Eval(node.Right, env)
+	}
+
 	right := e.Eval(node.Right, env)
 	if isError(right) {
 		return right
@@ -341,6 +697,8 @@ func (e *Evaluator) evalInfixExpression(node *InfixExpression, env *Environment)
 		return &BooleanValue{Value: IsTruthy(left) && IsTruthy(right)}
 	case node.Operator == "or":
 		return &BooleanValue{Value: IsTruthy(left) || IsTruthy(right)}
+	case node.Operator == "xor":
+		return &BooleanValue{Value: IsTruthy(left) != IsTruthy(right)}
 	case node.Operator == "is":
 		return &BooleanValue{Value: valuesEqual(left, right)}
 	}
@@ -351,6 +709,20 @@ func (e *Evaluator) evalInfixExpression(node *InfixExpression, env *Environment)
 		return e.evalIntegerInfixExpression(node.Operator, leftInt.Value, rightInt.Value)
 	}
 
+	// A BigInt mixed with an Integer works on the BigInt path
+	if leftBig, ok := bigIntOf(left); ok {
+		if rightBig, ok := bigIntOf(right); ok {
+			return evalBigIntInfixExpression(node.Operator, leftBig, rightBig)
+		}
+	}
+
+	// and a Decimal mixed with either on the Decimal path
+	if leftDec, ok := decimalOf(left); ok {
+		if rightDec, ok := decimalOf(right); ok {
+			return evalDecimalInfixExpression(node.Operator, leftDec, rightDec)
+		}
+	}
+
 	leftFloat, leftIsFloat := left.(*FloatValue)
 	rightFloat, rightIsFloat := right.(*FloatValue)
 	if leftIsFloat && rightIsFloat {
@@ -369,21 +741,144 @@ func (e *Evaluator) evalInfixExpression(node *InfixExpression, env *Environment)
 		return e.evalStringInfixExpression(node.Operator, leftStr.Value, rightStr.Value)
 	}
 
+	if structVal, ok := left.(*StructValue); ok {
+		if name, ok := operatorMethods[node.Operator]; ok {
+			method, ok := e.extensions[structVal.Type()][name]
+			if !ok {
+				return &ErrorValue{Message: fmt.Sprintf("operator %s not defined for %s (extend %s with a %s method)",
+					node.Operator, structVal.Type(), structVal.Type(), name)}
+			}
+			return e.callExtensionMethod(method, structVal, []Value{right})
+		}
+	}
+
+	switch node.Operator {
+	case ">", "<", ">=", "<=":
+		return &ErrorValue{Message: fmt.Sprintf("cannot compare %s and %s with %s", left.Type(), right.Type(), node.Operator)}
+	}
+
 	return &ErrorValue{Message: fmt.Sprintf("type mismatch: %s %s %s", left.Type(), node.Operator, right.Type())}
 }
 
-func (e *Evaluator) evalIntegerInfixExpression(op string, left, right int64) Value {
+// checkedArithmetic applies +, - or * to two Integers. ok is false when the
+// result doesn't fit in 64 bits, rather than letting it wrap around.
+func checkedArithmetic(op string, left, right int64) (result int64, ok bool) {
+	switch op {
+	case "+":
+		result = left + right
+		// Adding a positive number must make the result larger
+		return result, (result > left) == (right > 0)
+	case "-":
+		result = left - right
+		return result, (result < left) == (right > 0)
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, true
+		}
+		result = left * right
+		if result/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64) {
+			return result, false
+		}
+		return result, true
+	}
+	return 0, false
+}
+
+// bigIntOf returns v as a big.Int when it is an Integer or BigInt
+func bigIntOf(v Value) (*big.Int, bool) {
+	switch val := v.(type) {
+	case *IntegerValue:
+		return big.NewInt(val.Value), true
+	case *BigIntValue:
+		return val.Value, true
+	}
+	return nil, false
+}
+
+func evalBigIntInfixExpression(op string, left, right *big.Int) Value {
+	switch op {
+	case "+":
+		return &BigIntValue{Value: new(big.Int).Add(left, right)}
+	case "-":
+		return &BigIntValue{Value: new(big.Int).Sub(left, right)}
+	case "*":
+		return &BigIntValue{Value: new(big.Int).Mul(left, right)}
+	case "/":
+		if right.Sign() == 0 {
+			return &ErrorValue{Message: "division by zero"}
+		}
+		// Quo truncates toward zero, like Integer division
+		return &BigIntValue{Value: new(big.Int).Quo(left, right)}
+	case "%":
+		if right.Sign() == 0 {
+			return &ErrorValue{Message: "division by zero"}
+		}
+		return &BigIntValue{Value: new(big.Int).Rem(left, right)}
+	case ">":
+		return &BooleanValue{Value: left.Cmp(right) > 0}
+	case "<":
+		return &BooleanValue{Value: left.Cmp(right) < 0}
+	case ">=":
+		return &BooleanValue{Value: left.Cmp(right) >= 0}
+	case "<=":
+		return &BooleanValue{Value: left.Cmp(right) <= 0}
+	default:
+		return &ErrorValue{Message: fmt.Sprintf("unknown operator: BigInt %s BigInt", op)}
+	}
+}
+
+// decimalOf returns v as a big.Rat when it is a Decimal, Integer or BigInt
+func decimalOf(v Value) (*big.Rat, bool) {
+	if d, ok := v.(*DecimalValue); ok {
+		return d.Value, true
+	}
+	if n, ok := bigIntOf(v); ok {
+		return new(big.Rat).SetInt(n), true
+	}
+	return nil, false
+}
+
+func evalDecimalInfixExpression(op string, left, right *big.Rat) Value {
 	switch op {
 	case "+":
-		return &IntegerValue{Value: left + right}
+		return &DecimalValue{Value: new(big.Rat).Add(left, right)}
 	case "-":
-		return &IntegerValue{Value: left - right}
+		return &DecimalValue{Value: new(big.Rat).Sub(left, right)}
 	case "*":
-		return &IntegerValue{Value: left * right}
+		return &DecimalValue{Value: new(big.Rat).Mul(left, right)}
+	case "/":
+		if right.Sign() == 0 {
+			return &ErrorValue{Message: "division by zero"}
+		}
+		return &DecimalValue{Value: new(big.Rat).Quo(left, right)}
+	case ">":
+		return &BooleanValue{Value: left.Cmp(right) > 0}
+	case "<":
+		return &BooleanValue{Value: left.Cmp(right) < 0}
+	case ">=":
+		return &BooleanValue{Value: left.Cmp(right) >= 0}
+	case "<=":
+		return &BooleanValue{Value: left.Cmp(right) <= 0}
+	default:
+		return &ErrorValue{Message: fmt.Sprintf("unknown operator: Decimal %s Decimal", op)}
+	}
+}
+
+func (e *Evaluator) evalIntegerInfixExpression(op string, left, right int64) Value {
+	switch op {
+	case "+", "-", "*":
+		result, ok := checkedArithmetic(op, left, right)
+		if !ok {
+			return &ErrorValue{Message: fmt.Sprintf("integer overflow: %d %s %d", left, op, right)}
+		}
+		return &IntegerValue{Value: result}
 	case "/":
 		if right == 0 {
 			return &ErrorValue{Message: "division by zero"}
 		}
+		if left == math.MinInt64 && right == -1 {
+			return &ErrorValue{Message: fmt.Sprintf("integer overflow: %d / %d", left, right)}
+		}
 		return &IntegerValue{Value: left / right}
 	case "%":
 		if right == 0 {
@@ -459,7 +954,7 @@ func (e *Evaluator) evalAssignmentExpression(node *AssignmentExpression, env *En
 
 	mut, isMutable := existing.(*MutableValue)
 	if !isMutable {
-		return &ErrorValue{Message: fmt.Sprintf("%s is not mutable", node.Name.Value)}
+		return &ErrorValue{Message: fmt.Sprintf("cannot reassign %s: use Mutable to allow reassignment", node.Name.Value)}
 	}
 
 	mut.Value = UnwrapValue(val)
@@ -503,17 +998,62 @@ func (e *Evaluator) evalCallExpression(node *CallExpression, env *Environment) V
 	}
 
 	function := e.Eval(node.Function, env)
+	if isError(function) {
+		return function
+	}
 
-	args := e.evalExpressions(node.Arguments, env)
+	args, err := e.evalExpressions(node.Arguments, env)
+	if err != nil {
+		return err
+	}
 
+	if e.tests != nil {
+		e.tests.line = node.Token.Line
+	}
 	return e.applyFunction(function, args, env)
 }
 
 func (e *Evaluator) evalMethodCall(member *MemberExpression, args []Expression, env *Environment) Value {
 	obj := e.Eval(member.Object, env)
 
-	methodName := member.Member.Value
-	argValues := e.evalExpressions(args, env)
+	// obj?.method() skips the call, arguments included, when obj is None
+	if member.Optional {
+		if isError(obj) {
+			return obj
+		}
+		inner, present := optionalTarget(obj)
+		if !present {
+			return &OptionValue{IsSome: false}
+		}
+		obj = inner
+	}
+
+	// An error receiver propagates, except to catch, which recovers it
+	if isError(obj) && member.Member.Value != "catch" {
+		return obj
+	}
+
+	argValues, err := e.evalExpressions(args, env)
+	if err != nil {
+		return err
+	}
+
+	result := e.invokeMethod(obj, member.Member.Value, argValues, env)
+	if member.Optional && !isError(result) {
+		return optionalResult(result)
+	}
+	return result
+}
+
+// invokeMethod calls the method named methodName on obj
+func (e *Evaluator) invokeMethod(obj Value, methodName string, argValues []Value, env *Environment) Value {
+	// A struct's own extension methods come before the built-in struct
+	// methods, so adding a builtin such as fields() never hides user code
+	if _, ok := UnwrapValue(obj).(*StructValue); ok {
+		if method, ok := e.extensions[obj.Type()][methodName]; ok {
+			return e.callExtensionMethod(method, obj, argValues)
+		}
+	}
 
 	// Check for built-in methods
 	result := e.evalBuiltinMethod(obj, methodName, argValues, env)
@@ -525,29 +1065,99 @@ func (e *Evaluator) evalMethodCall(member *MemberExpression, args []Expression,
 	typeName := obj.Type()
 	if extMethods, ok := e.extensions[typeName]; ok {
 		if method, ok := extMethods[methodName]; ok {
-			// Create new environment with 'this' bound to the object
-			extEnv := NewEnclosedEnvironment(method.Env)
-			extEnv.Set("this", obj)
-
-			// Bind parameters
-			for i, param := range method.Parameters {
-				if i < len(argValues) {
-					extEnv.Set(param.Name.Value, argValues[i])
-				}
-			}
+			return e.callExtensionMethod(method, obj, argValues)
+		}
+	}
 
-			// Evaluate the method body directly
-			result := e.Eval(method.Body, extEnv)
-			return e.unwrapReturnValue(result)
+	// A struct field holding a function can be called like a method
+	if structVal, ok := UnwrapValue(obj).(*StructValue); ok {
+		if field, ok := structVal.Fields[methodName]; ok {
+			if !isCallable(field) {
+				return &ErrorValue{Message: fmt.Sprintf("field %s of %s is not a function", methodName, structVal.Type())}
+			}
+			return e.applyFunction(field, argValues, env)
 		}
 	}
 
 	return &ErrorValue{Message: fmt.Sprintf("undefined method %s on %s", methodName, typeName)}
 }
 
+// callExtensionMethod runs an extension method with 'this' bound to obj
+func (e *Evaluator) callExtensionMethod(method *FunctionValue, obj Value, args []Value) Value {
+	if err := e.enterCall(); err != nil {
+		return err
+	}
+	defer func() { e.depth-- }()
+
+	// Create new environment with 'this' bound to the object
+	extEnv := NewEnclosedEnvironment(method.Env)
+	extEnv.Set("this", obj)
+
+	// Bind parameters
+	for i, param := range method.Parameters {
+		if i < len(args) {
+			extEnv.Set(param.Name.Value, args[i])
+		}
+	}
+
+	// Evaluate the method body directly
+	result := e.Eval(method.Body, extEnv)
+	return e.unwrapReturnValue(result)
+}
+
+// displayString returns the text print and str show for v. A struct whose
+// type has a toString extension method is shown by calling it.
+func (e *Evaluator) displayString(v Value) Value {
+	v = UnwrapValue(v)
+	if structVal, ok := v.(*StructValue); ok {
+		if method, ok := e.extensions[structVal.Type()]["toString"]; ok {
+			result := e.callExtensionMethod(method, structVal, nil)
+			if isError(result) {
+				return result
+			}
+			text, ok := UnwrapValue(result).(*StringValue)
+			if !ok {
+				return &ErrorValue{Message: fmt.Sprintf("%s.toString() must return a String, got %s", structVal.Type(), result.Type())}
+			}
+			return text
+		}
+	}
+	return &StringValue{Value: v.String()}
+}
+
 func (e *Evaluator) evalBuiltinMethod(obj Value, method string, args []Value, env *Environment) Value {
+	// Mutable methods update the wrapper itself, so check them before unwrapping
+	if mut, ok := obj.(*MutableValue); ok {
+		if result := e.evalMutableMethod(mut, method, args); result != nil {
+			return result
+		}
+	}
 	obj = UnwrapValue(obj)
 
+	// Methods available on every value
+	switch method {
+	case "toString":
+		// Bytes convert back to the text they encode rather than their display form
+		if b, ok := obj.(*BytesValue); ok {
+			return &StringValue{Value: string(b.Value)}
+		}
+		return e.displayString(obj)
+	case "toJSON":
+		return valueToJSON(obj)
+	case "catch":
+		// Recover from an error value; anything else passes through unchanged
+		if len(args) != 1 {
+			return &ErrorValue{Message: "catch() requires 1 argument"}
+		}
+		if !isCallable(args[0]) {
+			return &ErrorValue{Message: "catch() argument must be a function"}
+		}
+		if err, ok := obj.(*ErrorValue); ok {
+			return e.applyFunction(args[0], []Value{&StringValue{Value: err.Message}}, env)
+		}
+		return obj
+	}
+
 	switch val := obj.(type) {
 	case *ListValue:
 		return e.evalListMethod(val, method, args, env)
@@ -555,20 +1165,52 @@ func (e *Evaluator) evalBuiltinMethod(obj Value, method string, args []Value, en
 		return e.evalMapMethod(val, method, args, env)
 	case *StringValue:
 		return e.evalStringMethod(val, method, args)
+	case *BytesValue:
+		return e.evalBytesMethod(val, method, args)
+	case *StructValue:
+		return e.evalStructMethod(val, method, args)
+	case *IntegerValue:
+		return e.evalIntegerMethod(val, method, args)
+	case *FloatValue:
+		return e.evalFloatMethod(val, method, args)
+	case *DecimalValue:
+		return e.evalDecimalMethod(val, method, args)
 	case *ResultValue:
 		return e.evalResultMethod(val, method, args, env)
 	case *OptionValue:
 		return e.evalOptionMethod(val, method, args, env)
 	case *ModuleValue:
 		if member, ok := val.Exports.Get(method); ok {
-			return member
+			return e.applyFunction(member, args, env)
 		}
-		return nil
+		if method == "exports" {
+			return moduleExports(val)
+		}
+		return &ErrorValue{Message: fmt.Sprintf("undefined export %s in module %s", method, val.Name)}
+	case *EnumDefinition:
+		return newEnumValue(val, method, args)
 	}
 
 	return nil
 }
 
+// moduleExports lists the names a module defines, sorted, leaving out
+// _private ones
+func moduleExports(mod *ModuleValue) Value {
+	var names []string
+	for _, name := range mod.Exports.All() {
+		if !strings.HasPrefix(name, "_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	elements := make([]Value, len(names))
+	for i, name := range names {
+		elements[i] = &StringValue{Value: name}
+	}
+	return &ListValue{Elements: elements}
+}
+
 func (e *Evaluator) evalListMethod(list *ListValue, method string, args []Value, env *Environment) Value {
 	switch method {
 	case "length":
@@ -587,47 +1229,90 @@ func (e *Evaluator) evalListMethod(list *ListValue, method string, args []Value,
 			return &ErrorValue{Message: "append() requires 1 argument"}
 		}
 		return listAppend(list, args[0])
+	case "push":
+		return &ErrorValue{Method: "push", Input: list.String(),
+			Message: "push() requires a Mutable list; use append() to get a new list"}
 	case "map":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "map() requires 1 argument"}
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "map() argument must be a function"}
 		}
-		return listMap(list, fn, e, env)
+		return listMap(list, args[0], e, env)
 	case "filter":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "filter() requires 1 argument"}
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "filter() argument must be a function"}
 		}
-		return listFilter(list, fn, e, env)
+		return listFilter(list, args[0], e, env)
 	case "reduce":
 		if len(args) != 2 {
 			return &ErrorValue{Message: "reduce() requires 2 arguments"}
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "reduce() first argument must be a function"}
 		}
-		return listReduce(list, fn, args[1], e, env)
+		return listReduce(list, args[0], args[1], e, env)
 	case "find":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "find() requires 1 argument"}
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "find() argument must be a function"}
 		}
-		return listFind(list, fn, e, env)
+		return listFind(list, args[0], e, env)
 	case "contains":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "contains() requires 1 argument"}
 		}
 		return &BooleanValue{Value: listContains(list, args[0])}
+	case "unique":
+		return listUnique(list)
+	case "sort":
+		return listSort(list, e)
+	case "min", "max":
+		return listExtreme(list, method == "max", e)
+	case "maxBy", "minBy":
+		if len(args) != 1 {
+			return &ErrorValue{Message: method + "() requires 1 argument"}
+		}
+		if !isCallable(args[0]) {
+			return &ErrorValue{Message: method + "() argument must be a function"}
+		}
+		return listExtremeBy(list, args[0], method == "maxBy", e, env)
+	case "groupBy":
+		if len(args) != 1 {
+			return &ErrorValue{Message: "groupBy() requires 1 argument"}
+		}
+		if !isCallable(args[0]) {
+			return &ErrorValue{Message: "groupBy() argument must be a function"}
+		}
+		return listGroupBy(list, args[0], e, env)
+	case "partition":
+		if len(args) != 1 {
+			return &ErrorValue{Message: "partition() requires 1 argument"}
+		}
+		if !isCallable(args[0]) {
+			return &ErrorValue{Message: "partition() argument must be a function"}
+		}
+		return listPartition(list, args[0], e, env)
+	case "toMap":
+		return listToMap(list)
+	case "zipWith":
+		if len(args) != 2 {
+			return &ErrorValue{Message: "zipWith() requires 2 arguments"}
+		}
+		other, ok := UnwrapValue(args[0]).(*ListValue)
+		if !ok {
+			return &ErrorValue{Message: "zipWith() first argument must be a list"}
+		}
+		if !isCallable(args[1]) {
+			return &ErrorValue{Message: "zipWith() second argument must be a function"}
+		}
+		return listZipWith(list, other, args[1], e, env)
 	}
 	return nil
 }
@@ -665,6 +1350,8 @@ func (e *Evaluator) evalMapMethod(m *MapValue, method string, args []Value, env
 		return mapKeys(m)
 	case "values":
 		return mapValues(m)
+	case "items":
+		return mapItems(m)
 	case "contains":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "contains() requires 1 argument"}
@@ -683,14 +1370,26 @@ func (e *Evaluator) evalStringMethod(s *StringValue, method string, args []Value
 	case "length":
 		return stringLength(s)
 	case "split":
-		if len(args) != 1 {
-			return &ErrorValue{Message: "split() requires 1 argument"}
+		if len(args) < 1 || len(args) > 2 {
+			return &ErrorValue{Message: "split() requires 1 or 2 arguments"}
 		}
 		sep, ok := UnwrapValue(args[0]).(*StringValue)
 		if !ok {
 			return &ErrorValue{Message: "split() argument must be a string"}
 		}
+		if len(args) == 2 {
+			limit, ok := UnwrapValue(args[1]).(*IntegerValue)
+			if !ok {
+				return &ErrorValue{Message: "split() limit must be an integer"}
+			}
+			if limit.Value <= 0 {
+				return &ErrorValue{Message: fmt.Sprintf("split() limit must be positive, got %d", limit.Value)}
+			}
+			return stringSplitN(s, sep.Value, int(limit.Value))
+		}
 		return stringSplit(s, sep.Value)
+	case "splitLines":
+		return stringSplitLines(s)
 	case "contains":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "contains() requires 1 argument"}
@@ -706,6 +1405,237 @@ func (e *Evaluator) evalStringMethod(s *StringValue, method string, args []Value
 		return stringUpper(s)
 	case "lower":
 		return stringLower(s)
+	case "padLeft", "padRight":
+		if len(args) < 1 || len(args) > 2 {
+			return &ErrorValue{Message: fmt.Sprintf("%s() requires 1 or 2 arguments", method)}
+		}
+		width, ok := UnwrapValue(args[0]).(*IntegerValue)
+		if !ok {
+			return &ErrorValue{Message: fmt.Sprintf("%s() width must be an integer", method)}
+		}
+		if width.Value > maxPadWidth {
+			return &ErrorValue{Message: fmt.Sprintf("%s() width %d exceeds the maximum of %d", method, width.Value, maxPadWidth)}
+		}
+		pad := " "
+		if len(args) == 2 {
+			padVal, ok := UnwrapValue(args[1]).(*StringValue)
+			if !ok || padVal.Value == "" {
+				return &ErrorValue{Message: fmt.Sprintf("%s() pad must be a non-empty string", method)}
+			}
+			pad = padVal.Value
+		}
+		return stringPad(s, int(width.Value), pad, method == "padLeft")
+	case "toInt":
+		return stringToInt(s)
+	case "toFloat":
+		return stringToFloat(s)
+	case "fromJSON":
+		return jsonToValue(s.Value)
+	case "toBytes":
+		return &BytesValue{Value: []byte(s.Value)}
+	case "matches", "findAll", "replaceRegex":
+		want := 1
+		if method == "replaceRegex" {
+			want = 2
+		}
+		if len(args) != want {
+			return &ErrorValue{Message: fmt.Sprintf("%s() requires %d argument(s)", method, want)}
+		}
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			str, ok := UnwrapValue(arg).(*StringValue)
+			if !ok {
+				return &ErrorValue{Message: fmt.Sprintf("%s() arguments must be strings", method)}
+			}
+			strs[i] = str.Value
+		}
+		re, err := compileRegex(method, strs[0])
+		if err != nil {
+			return err
+		}
+		switch method {
+		case "matches":
+			return &BooleanValue{Value: re.MatchString(s.Value)}
+		case "findAll":
+			return stringFindAll(s, re)
+		default:
+			return &StringValue{Value: re.ReplaceAllString(s.Value, strs[1])}
+		}
+	}
+	return nil
+}
+
+func (e *Evaluator) evalBytesMethod(b *BytesValue, method string, args []Value) Value {
+	switch method {
+	case "length":
+		return &IntegerValue{Value: int64(len(b.Value))}
+	case "toList":
+		return bytesToList(b)
+	}
+	return nil
+}
+
+func (e *Evaluator) evalStructMethod(s *StructValue, method string, args []Value) Value {
+	switch method {
+	case "fields":
+		return structFields(s)
+	case "fieldNames":
+		return structFieldNames(s)
+	case "getField":
+		if len(args) != 1 {
+			return &ErrorValue{Message: "getField() requires 1 argument"}
+		}
+		name, ok := UnwrapValue(args[0]).(*StringValue)
+		if !ok {
+			return &ErrorValue{Message: "getField() argument must be a string"}
+		}
+		if val, ok := s.Fields[name.Value]; ok {
+			return &OptionValue{IsSome: true, Value: val}
+		}
+		return &OptionValue{IsSome: false}
+	case "setField":
+		if len(args) != 2 {
+			return &ErrorValue{Message: "setField() requires 2 arguments"}
+		}
+		name, ok := UnwrapValue(args[0]).(*StringValue)
+		if !ok {
+			return &ErrorValue{Message: "setField() name must be a string"}
+		}
+		if _, ok := s.Fields[name.Value]; !ok {
+			return &ErrorValue{Method: "setField", Input: name.Value,
+				Message: fmt.Sprintf("undefined field %s on %s", name.Value, s.Type())}
+		}
+		if hint := s.Definition.fieldType(name.Value); !e.valueMatchesAnnotation(args[1], hint) {
+			return &ErrorValue{Method: "setField", Input: name.Value,
+				Message: fmt.Sprintf("field %s of %s has type %s, got %s", name.Value, s.Type(), hint, UnwrapValue(args[1]).Type())}
+		}
+		return s.With(map[string]Value{name.Value: UnwrapValue(args[1])})
+	}
+	return nil
+}
+
+// valueMatchesAnnotation reports whether v may be stored where t is declared.
+// Only the outermost type is compared, and names the evaluator doesn't track,
+// such as aliases, interfaces and type parameters, accept any value.
+func (e *Evaluator) valueMatchesAnnotation(v Value, t *TypeAnnotation) bool {
+	if t == nil {
+		return true
+	}
+	if len(t.Members) > 0 {
+		for _, member := range t.Members {
+			if e.valueMatchesAnnotation(v, member) {
+				return true
+			}
+		}
+		return false
+	}
+	v = UnwrapValue(v)
+	switch t.Name {
+	case "Integer", "BigInt", "Decimal", "Float", "String", "Boolean", "Bytes",
+		"List", "Tuple", "Map", "Option", "Result":
+		return v.Type() == t.Name
+	case "Mutable":
+		if len(t.TypeParams) == 1 {
+			return e.valueMatchesAnnotation(v, t.TypeParams[0])
+		}
+		return true
+	}
+	if _, ok := e.structs[t.Name]; ok {
+		return v.Type() == t.Name
+	}
+	return true
+}
+
+func (e *Evaluator) evalMutableMethod(mv *MutableValue, method string, args []Value) Value {
+	switch method {
+	case "inc":
+		return mutableStep(mv, "inc", 1, args)
+	case "dec":
+		return mutableStep(mv, "dec", -1, args)
+	case "set":
+		if len(args) != 1 {
+			return &ErrorValue{Method: "set", Message: "set() requires 1 argument"}
+		}
+		mv.Value = UnwrapValue(args[0])
+		return mv.Value
+	}
+
+	if list, ok := mv.Value.(*ListValue); ok {
+		return e.evalMutableListMethod(mv, list, method, args)
+	}
+	return nil
+}
+
+// evalMutableListMethod handles list methods that replace the list held by a
+// Mutable. The old list is never modified, so other references to it are safe.
+func (e *Evaluator) evalMutableListMethod(mv *MutableValue, list *ListValue, method string, args []Value) Value {
+	switch method {
+	case "push":
+		if len(args) != 1 {
+			return &ErrorValue{Method: "push", Message: "push() requires 1 argument"}
+		}
+		mv.Value = listAppend(list, UnwrapValue(args[0]))
+		return mv.Value
+	}
+	return nil
+}
+
+func (e *Evaluator) evalIntegerMethod(i *IntegerValue, method string, args []Value) Value {
+	switch method {
+	case "abs":
+		return integerAbs(i)
+	case "sign":
+		return integerSign(i)
+	case "toInt":
+		return i
+	case "toFloat":
+		return &FloatValue{Value: float64(i.Value)}
+	}
+	return nil
+}
+
+func (e *Evaluator) evalFloatMethod(f *FloatValue, method string, args []Value) Value {
+	switch method {
+	case "abs":
+		return floatAbs(f)
+	case "sign":
+		return floatSign(f)
+	case "floor":
+		return floatFloor(f)
+	case "ceil":
+		return floatCeil(f)
+	case "round":
+		return floatRound(f)
+	case "toInt":
+		return floatToInt(f, "toInt")
+	case "toFloat":
+		return f
+	}
+	return nil
+}
+
+func (e *Evaluator) evalDecimalMethod(d *DecimalValue, method string, args []Value) Value {
+	switch method {
+	case "abs":
+		return &DecimalValue{Value: new(big.Rat).Abs(d.Value)}
+	case "sign":
+		return &IntegerValue{Value: int64(d.Value.Sign())}
+	case "round":
+		places := int64(0)
+		if len(args) > 1 {
+			return &ErrorValue{Message: "round() takes at most 1 argument"}
+		}
+		if len(args) == 1 {
+			n, ok := UnwrapValue(args[0]).(*IntegerValue)
+			if !ok || n.Value < 0 {
+				return &ErrorValue{Message: "round() places must be a non-negative Integer"}
+			}
+			places = n.Value
+		}
+		return decimalRound(d, places)
+	case "toFloat":
+		f, _ := d.Value.Float64()
+		return &FloatValue{Value: f}
 	}
 	return nil
 }
@@ -719,11 +1649,10 @@ func (e *Evaluator) evalResultMethod(r *ResultValue, method string, args []Value
 		if !r.IsOk {
 			return r // Short-circuit on error
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "then() argument must be a function"}
 		}
-		result := e.applyFunction(fn, []Value{r.Value}, env)
+		result := e.applyFunction(args[0], []Value{r.Value}, env)
 		// If the function returns a Result, return it; otherwise wrap in Ok
 		if res, ok := result.(*ResultValue); ok {
 			return res
@@ -736,17 +1665,27 @@ func (e *Evaluator) evalResultMethod(r *ResultValue, method string, args []Value
 		if !r.IsOk {
 			return r // Short-circuit on error
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "map() argument must be a function"}
 		}
-		result := e.applyFunction(fn, []Value{r.Value}, env)
+		result := e.applyFunction(args[0], []Value{r.Value}, env)
 		return &ResultValue{IsOk: true, Value: result}
 	case "unwrap":
 		if !r.IsOk {
 			return r.Error
 		}
 		return r.Value
+	case "expect":
+		// Like unwrap, but an Error stops with the caller's message followed
+		// by the underlying error
+		msg, errVal := expectMessage(args)
+		if errVal != nil {
+			return errVal
+		}
+		if !r.IsOk {
+			return &ErrorValue{Method: r.Error.Method, Message: msg + ": " + r.Error.Message}
+		}
+		return r.Value
 	case "unwrapOr":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "unwrapOr() requires 1 argument"}
@@ -766,6 +1705,15 @@ func (e *Evaluator) evalOptionMethod(o *OptionValue, method string, args []Value
 			return &ErrorValue{Message: "called unwrap on None"}
 		}
 		return o.Value
+	case "expect":
+		msg, errVal := expectMessage(args)
+		if errVal != nil {
+			return errVal
+		}
+		if !o.IsSome {
+			return &ErrorValue{Message: msg}
+		}
+		return o.Value
 	case "unwrapOr":
 		if len(args) != 1 {
 			return &ErrorValue{Message: "unwrapOr() requires 1 argument"}
@@ -781,11 +1729,10 @@ func (e *Evaluator) evalOptionMethod(o *OptionValue, method string, args []Value
 		if !o.IsSome {
 			return o // Return None
 		}
-		fn, ok := args[0].(*FunctionValue)
-		if !ok {
+		if !isCallable(args[0]) {
 			return &ErrorValue{Message: "map() argument must be a function"}
 		}
-		result := e.applyFunction(fn, []Value{o.Value}, env)
+		result := e.applyFunction(args[0], []Value{o.Value}, env)
 		return &OptionValue{IsSome: true, Value: result}
 	case "isSome":
 		return &BooleanValue{Value: o.IsSome}
@@ -795,18 +1742,40 @@ func (e *Evaluator) evalOptionMethod(o *OptionValue, method string, args []Value
 	return nil
 }
 
-func (e *Evaluator) evalExpressions(exprs []Expression, env *Environment) []Value {
+// expectMessage checks the argument of expect() and returns its message
+func expectMessage(args []Value) (string, Value) {
+	if len(args) != 1 {
+		return "", &ErrorValue{Message: "expect() requires 1 argument"}
+	}
+	msg, ok := UnwrapValue(args[0]).(*StringValue)
+	if !ok {
+		return "", &ErrorValue{Message: "expect() argument must be a string"}
+	}
+	return msg.Value, nil
+}
+
+// evalExpressions evaluates exprs in order, stopping at the first one that
+// fails and returning its error
+func (e *Evaluator) evalExpressions(exprs []Expression, env *Environment) ([]Value, Value) {
 	result := make([]Value, len(exprs))
 	for i, expr := range exprs {
 		evaluated := e.Eval(expr, env)
+		if isError(evaluated) {
+			return nil, evaluated
+		}
 		result[i] = evaluated
 	}
-	return result
+	return result, nil
 }
 
 func (e *Evaluator) applyFunction(fn Value, args []Value, callerEnv *Environment) Value {
 	switch function := fn.(type) {
 	case *FunctionValue:
+		if err := e.enterCall(); err != nil {
+			return err
+		}
+		defer func() { e.depth-- }()
+
 		oldFn := e.currentFn
 		e.currentFn = function.Name
 
@@ -820,11 +1789,36 @@ func (e *Evaluator) applyFunction(fn Value, args []Value, callerEnv *Environment
 		}
 
 		e.currentFn = oldFn
-		return e.unwrapReturnValue(evaluated)
+		return e.enrichFunctionError(function, args, e.unwrapReturnValue(evaluated))
 
 	case *BuiltinFunction:
+		if function.Call != nil {
+			return function.Call(e, callerEnv, args...)
+		}
 		return function.Fn(args...)
 
+	case *MemoizedFunction:
+		key := elementsHashKey(args)
+		if cached, ok := function.Cache[key]; ok {
+			return cached
+		}
+		result := e.applyFunction(function.Fn, args, callerEnv)
+		if !isError(result) {
+			function.Cache[key] = result
+		}
+		return result
+
+	case *ComposedFunction:
+		last := len(function.Functions) - 1
+		result := e.applyFunction(function.Functions[last], args, callerEnv)
+		for i := last - 1; i >= 0; i-- {
+			if isError(result) {
+				return result
+			}
+			result = e.applyFunction(function.Functions[i], []Value{result}, callerEnv)
+		}
+		return result
+
 	case *StructDefinition:
 		// Struct instantiation like User { ... } is handled elsewhere
 		// This is for when a struct is called like a function (which shouldn't happen)
@@ -835,6 +1829,32 @@ func (e *Evaluator) applyFunction(fn Value, args []Value, callerEnv *Environment
 	}
 }
 
+// enrichFunctionError records the failing function and its first argument
+// on a copy of an error result, so the formatted error shows where it came
+// from without changing the error the function may still hold
+func (e *Evaluator) enrichFunctionError(fn *FunctionValue, args []Value, result Value) Value {
+	if fn.Name == "" {
+		return result
+	}
+
+	var input Value
+	if len(args) > 0 {
+		input = args[0]
+	}
+
+	switch r := result.(type) {
+	case *ErrorValue:
+		enriched := *r
+		return EnrichError(&enriched, fn.Name, input)
+	case *ResultValue:
+		if !r.IsOk && r.Error != nil {
+			enriched := *r.Error
+			return &ResultValue{Error: EnrichError(&enriched, fn.Name, input)}
+		}
+	}
+	return result
+}
+
 func (e *Evaluator) extendFunctionEnv(fn *FunctionValue, args []Value) *Environment {
 	env := NewEnclosedEnvironment(fn.Env)
 	for i, param := range fn.Parameters {
@@ -858,25 +1878,90 @@ func (e *Evaluator) evalMemberExpression(node *MemberExpression, env *Environmen
 		return obj
 	}
 
+	if node.Optional {
+		return evalOptionalMember(obj, node.Member.Value)
+	}
+	return memberOf(obj, node.Member.Value)
+}
+
+// memberOf returns the field, variant or export of obj called name
+func memberOf(obj Value, name string) Value {
 	// Handle struct field access
 	if structVal, ok := UnwrapValue(obj).(*StructValue); ok {
-		if val, ok := structVal.Fields[node.Member.Value]; ok {
+		if val, ok := structVal.Fields[name]; ok {
 			return val
 		}
-		return &ErrorValue{Message: fmt.Sprintf("undefined field %s on %s", node.Member.Value, structVal.Type())}
+		return &ErrorValue{Message: fmt.Sprintf("undefined field %s on %s", name, structVal.Type())}
+	}
+
+	// Handle enum variants
+	if def, ok := obj.(*EnumDefinition); ok {
+		return enumVariant(def, name)
 	}
 
 	// Handle module access
 	if mod, ok := obj.(*ModuleValue); ok {
-		if val, ok := mod.Exports.Get(node.Member.Value); ok {
+		if val, ok := mod.Exports.Get(name); ok {
 			return val
 		}
-		return &ErrorValue{Message: fmt.Sprintf("undefined export %s in module %s", node.Member.Value, mod.Name)}
+		return &ErrorValue{Message: fmt.Sprintf("undefined export %s in module %s", name, mod.Name)}
 	}
 
 	return &ErrorValue{Message: fmt.Sprintf("cannot access member of %s", obj.Type())}
 }
 
+// evalOptionalMember evaluates obj?.member. It gives None when obj is None or
+// null, or has no such field or map key, and Some(value) otherwise.
+func evalOptionalMember(obj Value, name string) Value {
+	inner, present := optionalTarget(obj)
+	if !present {
+		return &OptionValue{IsSome: false}
+	}
+
+	switch v := UnwrapValue(inner).(type) {
+	case *StructValue:
+		if val, ok := v.Fields[name]; ok {
+			return optionalResult(val)
+		}
+		return &OptionValue{IsSome: false}
+	case *MapValue:
+		if val, ok := v.Pairs[name]; ok {
+			return optionalResult(val)
+		}
+		return &OptionValue{IsSome: false}
+	}
+
+	result := memberOf(inner, name)
+	if isError(result) {
+		return result
+	}
+	return optionalResult(result)
+}
+
+// optionalTarget returns the value ?. looks into: the contents of Some, or
+// obj itself. present is false for None and null.
+func optionalTarget(obj Value) (inner Value, present bool) {
+	switch v := UnwrapValue(obj).(type) {
+	case *OptionValue:
+		return v.Value, v.IsSome
+	case *NullValue:
+		return nil, false
+	}
+	return obj, true
+}
+
+// optionalResult wraps the result of a ?. step in Some. An Option is
+// returned as is so chains don't nest, and null becomes None.
+func optionalResult(val Value) Value {
+	switch v := UnwrapValue(val).(type) {
+	case *OptionValue:
+		return v
+	case *NullValue:
+		return &OptionValue{IsSome: false}
+	}
+	return &OptionValue{IsSome: true, Value: val}
+}
+
 func (e *Evaluator) evalIndexExpression(node *IndexExpression, env *Environment) Value {
 	left := e.Eval(node.Left, env)
 	if isError(left) {
@@ -902,6 +1987,16 @@ func (e *Evaluator) evalIndexExpression(node *IndexExpression, env *Environment)
 		}
 		return obj.Elements[idx.Value]
 
+	case *TupleValue:
+		idx, ok := index.(*IntegerValue)
+		if !ok {
+			return &ErrorValue{Message: "tuple index must be an integer"}
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(obj.Elements)) {
+			return &ErrorValue{Message: "index out of bounds"}
+		}
+		return obj.Elements[idx.Value]
+
 	case *MapValue:
 		key, ok := index.(*StringValue)
 		if !ok {
@@ -928,18 +2023,26 @@ func (e *Evaluator) evalIndexExpression(node *IndexExpression, env *Environment)
 }
 
 func (e *Evaluator) evalListLiteral(node *ListLiteral, env *Environment) Value {
-	elements := e.evalExpressions(node.Elements, env)
-	if len(elements) == 1 && isError(elements[0]) {
-		return elements[0]
+	elements, err := e.evalExpressions(node.Elements, env)
+	if err != nil {
+		return err
 	}
 	return &ListValue{Elements: elements}
 }
 
+func (e *Evaluator) evalTupleLiteral(node *TupleLiteral, env *Environment) Value {
+	elements, err := e.evalExpressions(node.Elements, env)
+	if err != nil {
+		return err
+	}
+	return &TupleValue{Elements: elements}
+}
+
 func (e *Evaluator) evalMapLiteral(node *MapLiteral, env *Environment) Value {
 	pairs := make(map[string]Value)
 
-	for keyNode, valueNode := range node.Pairs {
-		key := e.Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := e.Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
@@ -949,7 +2052,7 @@ func (e *Evaluator) evalMapLiteral(node *MapLiteral, env *Environment) Value {
 			return &ErrorValue{Message: "map key must be a string"}
 		}
 
-		value := e.Eval(valueNode, env)
+		value := e.Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
@@ -967,6 +2070,19 @@ func (e *Evaluator) evalStructLiteral(node *StructLiteral, env *Environment) Val
 	}
 
 	fields := make(map[string]Value)
+	for _, spreadNode := range node.Spreads {
+		spread := e.Eval(spreadNode, env)
+		if isError(spread) {
+			return spread
+		}
+		base, ok := UnwrapValue(spread).(*StructValue)
+		if !ok || base.Definition.Name != def.Name {
+			return &ErrorValue{Message: fmt.Sprintf("cannot spread %s into %s", UnwrapValue(spread).Type(), def.Name)}
+		}
+		for name, value := range base.Fields {
+			fields[name] = value
+		}
+	}
 	for name, valueNode := range node.Fields {
 		value := e.Eval(valueNode, env)
 		if isError(value) {
@@ -992,16 +2108,27 @@ func (e *Evaluator) evalWithExpression(node *WithExpression, env *Environment) V
 		return &ErrorValue{Message: fmt.Sprintf("with can only be used on structs, got %s", obj.Type())}
 	}
 
-	updates := make(map[string]Value)
-	for name, valueNode := range node.Updates {
-		value := e.Eval(valueNode, env)
+	// Apply updates in sorted order so "server" is replaced before a
+	// "server.port" update is applied to the result
+	names := make([]string, 0, len(node.Updates))
+	for name := range node.Updates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := e.Eval(node.Updates[name], env)
 		if isError(value) {
 			return value
 		}
-		updates[name] = value
+		updated, err := structVal.WithPath(strings.Split(name, "."), value)
+		if err != nil {
+			return &ErrorValue{Message: err.Error()}
+		}
+		structVal = updated
 	}
 
-	return structVal.With(updates)
+	return structVal
 }
 
 func (e *Evaluator) evalOptionExpression(node *OptionExpression, env *Environment) Value {
@@ -1061,9 +2188,22 @@ func (e *Evaluator) evalMatchExpression(node *MatchExpression, env *Environment)
 }
 
 func (e *Evaluator) matchPattern(value Value, matchCase *MatchCase, env *Environment) (bool, map[string]Value) {
+	value = UnwrapValue(value)
+	if matched, bindings := e.matchSinglePattern(value, matchCase.Pattern, matchCase.BindingVar, env); matched {
+		return true, bindings
+	}
+	for _, alt := range matchCase.Alternatives {
+		if matched, bindings := e.matchSinglePattern(value, alt, nil, env); matched {
+			return true, bindings
+		}
+	}
+	return false, nil
+}
+
+func (e *Evaluator) matchSinglePattern(value Value, pattern Expression, bindingVar *Identifier, env *Environment) (bool, map[string]Value) {
 	bindings := make(map[string]Value)
 
-	switch pat := matchCase.Pattern.(type) {
+	switch pat := pattern.(type) {
 	case *OptionExpression:
 		opt, ok := value.(*OptionValue)
 		if !ok {
@@ -1072,8 +2212,8 @@ func (e *Evaluator) matchPattern(value Value, matchCase *MatchCase, env *Environ
 		if pat.IsSome != opt.IsSome {
 			return false, nil
 		}
-		if pat.IsSome && matchCase.BindingVar != nil {
-			bindings[matchCase.BindingVar.Value] = opt.Value
+		if pat.IsSome && bindingVar != nil {
+			bindings[bindingVar.Value] = opt.Value
 		}
 		return true, bindings
 
@@ -1085,19 +2225,73 @@ func (e *Evaluator) matchPattern(value Value, matchCase *MatchCase, env *Environ
 		if pat.IsOk != res.IsOk {
 			return false, nil
 		}
-		if matchCase.BindingVar != nil {
+		if bindingVar != nil {
 			if res.IsOk {
-				bindings[matchCase.BindingVar.Value] = res.Value
+				bindings[bindingVar.Value] = res.Value
 			} else {
-				bindings[matchCase.BindingVar.Value] = res.Error
+				// Bind the message, matching the String error type the checker
+				// gives Error(e) and the argument catch passes
+				bindings[bindingVar.Value] = &StringValue{Value: res.Error.Message}
 			}
 		}
 		return true, bindings
 
 	case *Identifier:
-		// Wildcard pattern - matches anything
-		bindings[pat.Value] = value
+		// Wildcard pattern - matches anything; `_` matches without binding
+		if pat.Value != "_" {
+			bindings[pat.Value] = value
+		}
+		return true, bindings
+
+	case *StructLiteral:
+		// Struct pattern - same struct, every listed field matches its pattern
+		sv, ok := value.(*StructValue)
+		if !ok || sv.Definition.Name != pat.StructName.Value {
+			return false, nil
+		}
+		for name, fieldPattern := range pat.Fields {
+			fieldValue, ok := sv.Fields[name]
+			if !ok {
+				return false, nil
+			}
+			matched, fieldBindings := e.matchSinglePattern(UnwrapValue(fieldValue), fieldPattern, patternBinding(fieldPattern), env)
+			if !matched {
+				return false, nil
+			}
+			for k, v := range fieldBindings {
+				bindings[k] = v
+			}
+		}
+		return true, bindings
+
+	case *MemberExpression, *CallExpression:
+		// Enum pattern - same variant, every payload value matches its pattern
+		ev, ok := value.(*EnumValue)
+		if !ok {
+			return false, nil
+		}
+		enum, variant, payload, ok := splitEnumPattern(pat)
+		if !ok || ev.Enum != enum || ev.Variant != variant || len(payload) != len(ev.Values) {
+			return false, nil
+		}
+		for i, valuePattern := range payload {
+			matched, valueBindings := e.matchSinglePattern(ev.Values[i], valuePattern, patternBinding(valuePattern), env)
+			if !matched {
+				return false, nil
+			}
+			for k, v := range valueBindings {
+				bindings[k] = v
+			}
+		}
 		return true, bindings
+
+	case *IntegerLiteral, *FloatLiteral, *StringLiteral, *BooleanLiteral, *PrefixExpression:
+		// Literal pattern - matches an equal value
+		expected := e.Eval(pat, env)
+		if isError(expected) {
+			return false, nil
+		}
+		return valuesEqual(value, expected), bindings
 	}
 
 	return false, nil
@@ -1111,10 +2305,48 @@ func (e *Evaluator) evalMutableExpression(node *MutableExpression, env *Environm
 	return &MutableValue{Value: UnwrapValue(value)}
 }
 
+// traceNode writes the node's type and source line to the trace writer, as
+// "[line 3] DefStatement". Nodes without a position show "?" for the line.
+func (e *Evaluator) traceNode(node Node) {
+	line := "?"
+	if tok, ok := nodeToken(node); ok {
+		line = fmt.Sprint(tok.Line)
+	}
+	fmt.Fprintf(e.options.Trace, "[line %s] %s\n", line, reflect.TypeOf(node).Elem().Name())
+}
+
+// locateError returns an error produced by stmt with the line of stmt
+// recorded, unless a statement nested inside it already did. The error is
+// copied since it may be held in a variable and reported again later.
+func locateError(result Value, stmt Statement) Value {
+	err, ok := result.(*ErrorValue)
+	if !ok || err.Line > 0 {
+		return result
+	}
+	tok, ok := nodeToken(stmt)
+	if !ok {
+		return result
+	}
+	located := *err
+	located.Line = tok.Line
+	return &located
+}
+
+func labelName(label *Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.Value
+}
+
+// isError reports whether val stops evaluation: an error, or an exit request
+// which must unwind the same way. A return, break or continue reached inside
+// an expression (say an if used as an argument) also unwinds to the function
+// or loop that handles it.
 func isError(val Value) bool {
-	if val == nil {
-		return false
+	switch val.(type) {
+	case *ErrorValue, *ExitValue, *ReturnValue, *BreakValue, *ContinueValue:
+		return true
 	}
-	_, ok := val.(*ErrorValue)
-	return ok
+	return false
 }
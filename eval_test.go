@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// run evaluates source in a fresh sandbox and returns the last value
+func run(t *testing.T, source string) Value {
+	t.Helper()
+	return RunSandboxed(source, DefaultOptions())
+}
+
+// show renders a result for comparison, errors as "error: <message>"
+func show(v Value) string {
+	if err, ok := v.(*ErrorValue); ok {
+		return "error: " + err.Message
+	}
+	return v.String()
+}
+
+// captureOutput runs fn with stdout and stderr redirected to buffers
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	oldOut, oldErr := stdout, stderr
+	stdout, stderr = &out, &errOut
+	defer func() { stdout, stderr = oldOut, oldErr }()
+	fn()
+	return out.String(), errOut.String()
+}
+
+type evalCase struct {
+	name   string
+	source string
+	want   string
+}
+
+func runEvalCases(t *testing.T, cases []evalCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := show(run(t, tc.source)); got != tc.want {
+				t.Errorf("got %q, want %q\nsource:\n%s", got, tc.want, tc.source)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"end only", `range(4)`, "[0, 1, 2, 3]"},
+		{"start and end", `range(2, 5)`, "[2, 3, 4]"},
+		{"step", `range(0, 10, 3)`, "[0, 3, 6, 9]"},
+		{"negative step", `range(5, 0, -2)`, "[5, 3, 1]"},
+		{"empty", `range(3, 3)`, "[]"},
+		{"empty for wrong direction", `range(0, 5, -1)`, "[]"},
+		{"zero step", `range(0, 5, 0)`, "error: range() step cannot be zero"},
+	})
+}
+
+func TestLoops(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"index and value", `
+def total = Mutable(0)
+for i, v in [10, 20, 30] {
+    total == total + i * v
+}
+total`, "80"},
+		{"map key and value", `
+def keys = Mutable("")
+for k, v in {"a": 1, "b": 2} {
+    keys == keys + k + str(v)
+}
+keys`, "a1b2"},
+		{"while else runs on completion", `
+def i = Mutable(0)
+def done = Mutable(false)
+while i < 3 {
+    i == i + 1
+} else {
+    done == true
+}
+done`, "true"},
+		{"repeat until", `
+def i = Mutable(0)
+repeat {
+    i == i + 1
+} until i >= 3
+i`, "3"},
+		{"labeled break", `
+def hits = Mutable(0)
+outer: for a in [1, 2, 3] {
+    for b in [1, 2, 3] {
+        if b is 2 {
+            continue outer
+        }
+        if a is 3 {
+            break outer
+        }
+        hits == hits + 1
+    }
+}
+hits`, "2"},
+		{"break inside if", `
+def kept = Mutable([])
+for n in [1, 2, 3, 4] {
+    if n > 2 {
+        break
+    }
+    kept.push(n)
+}
+kept`, "[1, 2]"},
+	})
+}
+
+func TestStringsAndNumbers(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"trailing commas", `[1, 2, 3,].length()`, "3"},
+		{"abs", `(-5).abs()`, "5"},
+		{"abs of the smallest Integer", `(-9223372036854775807 - 1).abs()`, "error: integer overflow: abs(-9223372036854775808)"},
+		{"float sign", `(-2.5).sign()`, "-1"},
+		{"toInt", `"42".toInt()`, "Ok(42)"},
+		{"float toInt truncates", `(-2.9).toInt()`, "-2"},
+		{"float toInt at the lower bound", `(-9223372036854775808.0).toInt()`, "-9223372036854775808"},
+		{"float toInt of NaN", `float("NaN").toInt()`, "error: cannot convert NaN to an Integer"},
+		{"float toInt of infinity", `float("-Inf").toInt()`, "error: -Inf is out of range for an Integer"},
+		{"float toInt above the range", `(9223372036854775807.0).toInt()`, "error: 9.223372036854776e+18 is out of range for an Integer"},
+		{"int of a large float", `int(float("1e300"))`, "error: 1e+300 is out of range for an Integer"},
+		{"split limit", `"a,b,c".split(",", 2)`, "[a, b,c]"},
+		{"split limit of one", `"a,b,c".split(",", 1)`, "[a,b,c]"},
+		{"split zero limit", `"a,b,c".split(",", 0)`, "error: split() limit must be positive, got 0"},
+		{"split negative limit", `"a,b,c".split(",", -1)`, "error: split() limit must be positive, got -1"},
+		{"padLeft", `"7".padLeft(3, "0")`, "007"},
+		{"padRight at the maximum width", `"7".padRight(1048576).length()`, "1048576"},
+		{"padLeft width too large", `"7".padLeft(1048577)`, "error: padLeft() width 1048577 exceeds the maximum of 1048576"},
+		{"format number", `formatNumber(1234567.5, 2)`, "1,234,567.50"},
+		{"parseInt base", `parseInt("ff", 16)`, "Ok(255)"},
+		{"parseInt failure", `parseInt("x")`, `Error(cannot convert "x" to integer)`},
+		{"char and ord", `char(ord("a") + 1)`, "b"},
+		{"raw string", `r"a\nb"`, `a\nb`},
+		{"raw string keeps the backslash", `len(r"\n")`, "2"},
+		{"raw Windows path", `r"C:\new\path"`, `C:\new\path`},
+		{"xor", `true xor false`, "true"},
+		{"overflow", `9223372036854775807 + 1`, "error: integer overflow: 9223372036854775807 + 1"},
+		{"bigint", `bigint(9223372036854775807) + 1`, "9223372036854775808"},
+		{"decimal", `decimal("0.1") + decimal("0.2")`, "0.3"},
+	})
+}
+
+func TestTripleQuotedString(t *testing.T) {
+	source := "def s = \"\"\"one\n  \"two\" \\n\"\"\"\ns"
+	if got, want := show(run(t, source)), "one\n  \"two\" \\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Lines inside the literal count towards later positions
+	result := run(t, "def s = \"\"\"a\nb\nc\"\"\"\n[1][5]")
+	if err, ok := result.(*ErrorValue); !ok || err.Line != 4 {
+		t.Fatalf("got %s, want the error from line 4", show(result))
+	}
+}
+
+func TestRegex(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"matches", `"abc123".matches(r"^[a-z]+\d+$")`, "true"},
+		{"no match", `"abc".matches(r"\d")`, "false"},
+		{"findAll", `"a1 b22".findAll(r"\d+")`, "[1, 22]"},
+		{"capture groups", `"x=1, y=2".findAll(r"(\w)=(\d)")`, "[[x, 1], [y, 2]]"},
+		{"replaceRegex", `"2024-01-05".replaceRegex(r"(\d+)-(\d+)-(\d+)", "$3/$2/$1")`, "05/01/2024"},
+		{"invalid pattern", `"(".matches("(")`, "error: invalid regex: error parsing regexp: missing closing ): `(`"},
+	})
+}
+
+func TestFlagsModule(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"flags and positionals", `
+import flags
+flags.parse(["--name", "Al", "pos1", "--verbose"])`, `({"name": Al, "verbose": true}, [pos1])`},
+		{"lone flag is a Boolean", `
+import flags
+def (opts, rest) = flags.parse(["--verbose"])
+type(opts["verbose"])`, "Boolean"},
+		{"key=value and --", `
+import flags
+flags.parse(["--k=v", "--", "--x"])`, `({"k": v}, [--x])`},
+	})
+}
+
+func TestBytesEncoding(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"base64Encode", `base64Encode(bytes("héllo, world"))`, "aMOpbGxvLCB3b3JsZA=="},
+		{"base64 round trip", `
+match base64Decode(base64Encode(bytes("héllo, world"))) {
+    Ok(b) -> b.toString()
+    Error(e) -> e
+}`, "héllo, world"},
+		{"hexEncode", `hexEncode("hi".toBytes())`, "6869"},
+		{"hex round trip", `
+match hexDecode(hexEncode(bytes("héllo, world"))) {
+    Ok(b) -> b.toString()
+    Error(e) -> e
+}`, "héllo, world"},
+		{"invalid hex", `hexDecode("zz")`, "Error(invalid hex: encoding/hex: invalid byte: U+007A 'z')"},
+		{"invalid base64", `base64Decode("!!")`, "Error(invalid base64: illegal base64 data at input byte 0)"},
+	})
+}
+
+func TestJSON(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"toJSON of a nested map", `{"p": [1, 2], "q": {"r": "s"}}.toJSON()`, `{"p":[1,2],"q":{"r":"s"}}`},
+		{"round trip", `
+def v = {"p": [1, 2], "q": {"r": "s"}}
+match v.toJSON().fromJSON() {
+    Ok(back) -> back is v
+    Error(e) -> false
+}`, "true"},
+		{"struct toJSON", `
+struct P {
+    name: String
+    tags: List[String]
+}
+P { name: "a", tags: ["x"] }.toJSON()`, `{"name":"a","tags":["x"]}`},
+		{"invalid JSON", `"{bad".fromJSON()`, "Error(invalid JSON: invalid character 'b' looking for beginning of object key string)"},
+	})
+}
+
+func TestCollections(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"tuple index", `(1, "a")[1]`, "a"},
+		{"destructuring", "def (a, b) = (1, 2)\na + b", "3"},
+		{"groupBy", `[1, 2, 3].groupBy({ n -> if n % 2 is 0 { "even" } else { "odd" } })`, `{"even": [2], "odd": [1, 3]}`},
+		{"partition", `[1, 2, 3, 4].partition({ n -> n > 2 })`, "[[3, 4], [1, 2]]"},
+		{"maxBy", `["aa", "b", "ccc"].maxBy({ s -> len(s) })`, "Some(ccc)"},
+		{"minBy with a builtin", `["aa", "b", "ccc"].minBy(len)`, "Some(b)"},
+		{"maxBy stops at a callback error", `[0, 1].maxBy({ i -> [1][i + 1] })`, "error: index out of bounds"},
+		{"groupBy with a composed function", `[1, 2].groupBy(compose(str, { n -> n * 2 }))`, `{"2": [1], "4": [2]}`},
+		{"groupBy stops at a callback error", `[0, 1].groupBy({ i -> [1][i + 1] })`, "error: index out of bounds"},
+		{"partition with a memoized function", `[1, 2].partition(memoize({ n -> n > 1 }))`, "[[2], [1]]"},
+		{"partition stops at a callback error", `[0, 1].partition({ i -> [1][i + 1] })`, "error: index out of bounds"},
+		{"zipWith", `[1, 2].zipWith([10, 20], { a, b -> a + b })`, "[11, 22]"},
+		{"fill", `fill(3, { i -> i * i })`, "[0, 1, 4]"},
+		{"fill stops at a callback error", `fill(3, { i -> [1][i] })`, "error: index out of bounds"},
+		{"repeat", `repeat("a", 3)`, "[a, a, a]"},
+		{"repeat negative count", `repeat(1, -2)`, "[]"},
+		{"repeat call as a statement", "repeat(0, 2)", "[0, 0]"},
+		{"repeat builtin inside a repeat loop", `
+def xs = Mutable([])
+repeat {
+    xs.push(repeat("x", 2).length())
+} until xs.length() is 2
+xs`, "[2, 2]"},
+		{"map keys sorted", `{"b": 2, "a": 1}.keys()`, "[a, b]"},
+		{"copy is deep", `
+def a = Mutable([1])
+def b = copy(a)
+a.push(2)
+b`, "[1]"},
+		{"hash of equal values", `hash([1, 2]) is hash([1, 2])`, "true"},
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"recursive def lambda", `
+def fact = { n -> if n <= 1 { 1 } else { n * fact(n - 1) } }
+fact(5)`, "120"},
+		{"compose", `compose({ x -> x + 1 }, { x -> x * 2 })(5)`, "11"},
+		{"map with a composed function", `[1, 2].map(compose(str, { x -> x * 2 }))`, "[2, 4]"},
+		{"map with a builtin", `[1, 2].map(str)`, "[1, 2]"},
+		{"filter with a composed function", `["a", "bb"].filter(compose({ n -> n > 1 }, len))`, "[bb]"},
+		{"find with a builtin", `["", "a"].find(len)`, "Some(a)"},
+		{"reduce with a memoized function", `[1, 2, 3].reduce(memoize({ a, b -> a + b }), 0)`, "6"},
+		{"memoize", `memoize({ x -> x * 10 })(4)`, "40"},
+		{"generic function", `
+fun first[T](xs: List[T]) -> T {
+    return xs[0]
+}
+first(["a", "b"])`, "a"},
+		{"def reuses a builtin name", "def len = 3\nlen + 1", "4"},
+		{"error in list element propagates", `[1, [1][5]]`, "error: index out of bounds"},
+		{"unread held error", "def x = [1][5]\n1", "1"},
+		{"held error used later", "def x = [1][5]\nx + 1", "error: index out of bounds"},
+		{"caught held error", `
+fun f() -> Integer {
+    def a = [1][3]
+    return a.catch({ msg -> 7 })
+}
+f()`, "7"},
+		{"method on an error propagates", `[1][5].length()`, "error: index out of bounds"},
+		{"method on a held error propagates", "def x = [1][5]\nx.toString()", "error: index out of bounds"},
+		{"optional method on an error propagates", `[1][5]?.length()`, "error: index out of bounds"},
+		{"error passed to a builtin propagates", `type([1][5])`, "error: index out of bounds"},
+		{"error arm binds the message", `
+def r: Result[Integer, String] = Error("bad")
+match r {
+    Ok(v) -> "ok"
+    Error(e) -> type(e) + ": " + e
+}`, "String: bad"},
+		{"error in argument propagates", `
+fun id(x: Integer) -> Integer {
+    return x
+}
+id([1][5])`, "error: index out of bounds"},
+	})
+}
+
+func TestOptionAndResult(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"coalesce none", `None ?? 5`, "5"},
+		{"coalesce some", `Some(2) ?? 5`, "2"},
+		{"optional member on map", `{"a": 1}?.b`, "None"},
+		{"expect on none", `None.expect("missing")`, "error: missing"},
+		{"toOption", `toOption(3)`, "Some(3)"},
+		{"toResult none", `toResult(None, "absent")`, "Error(absent)"},
+		{"catch", `[1][4].catch({ msg -> 0 })`, "0"},
+		{"Result.map with a builtin", `Ok("abc").map(len)`, "Ok(3)"},
+		{"Result.then with a builtin", `Ok("42").then(parseInt)`, "Ok(42)"},
+		{"Result.then with a failing builtin", `Ok("x").then(parseInt)`, `Error(cannot convert "x" to integer)`},
+		{"Option.map with a builtin", `Some([1, 2]).map(len)`, "Some(2)"},
+		{"Option.map with a composed function", `Some(2).map(compose(str, { n -> n * 2 }))`, "Some(4)"},
+	})
+}
+
+func TestStructsAndEnums(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{"struct equality", `
+struct P {
+    x: Integer
+}
+P { x: 1 } is P { x: 1 }`, "true"},
+		{"with on nested field", `
+struct In {
+    v: Integer
+}
+struct Out {
+    inner: In
+}
+def o = Out { inner: In { v: 1 } }
+o.with { "inner.v": 2 }.inner.v`, "2"},
+		{"struct spread", `
+struct P {
+    x: Integer
+    y: Integer
+}
+def a = P { x: 1, y: 2 }
+P { ...a, y: 5 }.y`, "5"},
+		{"operator overload", `
+struct V {
+    x: Integer
+}
+extend V {
+    fun plus(other: V) -> V {
+        return V { x: this.x + other.x }
+    }
+}
+(V { x: 1 } + V { x: 2 }).x`, "3"},
+		{"operator overloads on two types", `
+struct V {
+    x: Integer
+}
+struct W {
+    s: String
+}
+extend V {
+    fun plus(other: V) -> V {
+        return V { x: this.x + other.x }
+    }
+}
+extend W {
+    fun plus(other: W) -> W {
+        return W { s: this.s + other.s }
+    }
+}
+str((V { x: 1 } + V { x: 2 }).x) + (W { s: "a" } + W { s: "b" }).s`, "3ab"},
+		{"extension method wins over builtin struct method", `
+struct Server {
+    host: String
+}
+extend Server {
+    fun fields() -> String {
+        return "custom"
+    }
+}
+Server { host: "a" }.fields()`, "custom"},
+		{"builtin struct method", `
+struct Server {
+    host: String
+}
+Server { host: "a" }.fields()`, `{"host": a}`},
+		{"setField checks the declared type", `
+struct Server {
+    host: String
+}
+Server { host: "a" }.setField("host", 42)`, "error: field host of Server has type String, got Integer"},
+		{"setField", `
+struct Server {
+    host: String
+}
+Server { host: "a" }.setField("host", "b").host`, "b"},
+		{"enum match", `
+enum Shape {
+    Circle(Float)
+    Dot
+}
+def s = Shape.Circle(2.0)
+match s {
+    Shape.Circle(r) -> r * 2.0
+    Shape.Dot -> 0.0
+}`, "4"},
+	})
+}
+
+func TestMaxSteps(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxSteps = 1000
+	result := RunSandboxed("def i = Mutable(0)\nwhile true {\n    i == i + 1\n}", opts)
+	if err, ok := result.(*ErrorValue); !ok || !strings.Contains(err.Message, "limit") {
+		t.Fatalf("got %s, want a step limit error", show(result))
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxDepth = 50
+	result := RunSandboxed("fun f(n: Integer) -> Integer {\n    return f(n + 1)\n}\nf(0)", opts)
+	if err, ok := result.(*ErrorValue); !ok || !strings.Contains(err.Message, "depth") {
+		t.Fatalf("got %s, want a depth limit error", show(result))
+	}
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	if got := show(RunSandboxed("def shared = 1\nshared", DefaultOptions())); got != "1" {
+		t.Fatalf("first run got %s", got)
+	}
+	if got := show(RunSandboxed("shared", DefaultOptions())); !strings.Contains(got, "shared") || !strings.HasPrefix(got, "error") {
+		t.Fatalf("second run saw the first run's binding: %s", got)
+	}
+}
+
+func TestImportsDisabled(t *testing.T) {
+	opts := DefaultOptions()
+	opts.AllowImports = false
+	got := show(RunSandboxed("import utils\n1", opts))
+	if got != "error: cannot import utils: imports disabled" {
+		t.Fatalf("got %s", got)
+	}
+	// Built-in modules never touch the disk, so they stay available
+	if got := show(RunSandboxed("import time\ntime.format(0, \"%Y\")", opts)); got != "1970" {
+		t.Fatalf("built-in module: got %s", got)
+	}
+}
+
+func TestHostBuiltins(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Builtins = map[string]HostFunction{
+		"double": {
+			Fn: func(args ...Value) Value {
+				return &IntegerValue{Value: args[0].(*IntegerValue).Value * 2}
+			},
+			Type: &FunctionType{Parameters: []Type{&IntegerType{}}, Return: &IntegerType{}},
+		},
+	}
+	if got := show(RunSandboxed("double(21)", opts)); got != "42" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestExit(t *testing.T) {
+	result := run(t, "exit(2)\n1")
+	exit, ok := result.(*ExitValue)
+	if !ok || exit.Code != 2 {
+		t.Fatalf("got %s, want exit code 2", show(result))
+	}
+}
+
+func TestPrintOutput(t *testing.T) {
+	out, errOut := captureOutput(t, func() {
+		run(t, `print("a", 1)
+println("")
+printlnParts([1, 2, 3], ", ")
+eprintln("oops")`)
+	})
+	if out != "a 1\n1, 2, 3\n" {
+		t.Errorf("stdout = %q", out)
+	}
+	if errOut != "oops\n" {
+		t.Errorf("stderr = %q", errOut)
+	}
+}
+
+func TestLog(t *testing.T) {
+	_, errOut := captureOutput(t, func() {
+		run(t, `log("info", "signed in", {"name": "Ada L", "id": 42})`)
+	})
+	if want := "[INFO] signed in id=42 name=\"Ada L\"\n"; errOut != want {
+		t.Fatalf("got %q, want %q", errOut, want)
+	}
+	if got := show(run(t, `log("loud", "x")`)); !strings.Contains(got, "level must be one of") {
+		t.Fatalf("unknown level: got %s", got)
+	}
+}
+
+func TestMatchArmErrorLine(t *testing.T) {
+	result := run(t, "def xs = [1]\nmatch 2 {\n    1 -> 0\n    _ -> xs[5]\n}")
+	err, ok := result.(*ErrorValue)
+	if !ok || err.Line != 4 {
+		t.Fatalf("got %s, want the error from line 4", show(result))
+	}
+}
+
+func TestLocateErrorCopies(t *testing.T) {
+	shared := &ErrorValue{Message: "bad"}
+	stmt := &ExpressionStatement{Token: Token{Literal: "x", Line: 7}}
+	located, ok := locateError(shared, stmt).(*ErrorValue)
+	if !ok || located.Line != 7 {
+		t.Fatalf("located = %s", show(located))
+	}
+	if shared.Line != 0 {
+		t.Fatalf("the original error was given line %d", shared.Line)
+	}
+	if again := locateError(located, &ExpressionStatement{Token: Token{Line: 9}}); again.(*ErrorValue).Line != 7 {
+		t.Fatalf("an outer statement replaced the line")
+	}
+}
+
+func TestFunctionErrorContextIsCopied(t *testing.T) {
+	result := run(t, `
+def r: Result[Integer, String] = Error("bad")
+fun get(n: Integer) -> Result[Integer, String] {
+    return r
+}
+def got = get(1)
+(got, r)`)
+	pair, ok := result.(*TupleValue)
+	if !ok {
+		t.Fatalf("got %s", show(result))
+	}
+	got, held := pair.Elements[0].(*ResultValue), pair.Elements[1].(*ResultValue)
+	if got.Error.Method != "get" || got.Error.Input != "1" {
+		t.Fatalf("returned error = %+v", got.Error)
+	}
+	if held.Error.Method != "" || held.Error.Input != "" {
+		t.Fatalf("the held error was changed to %+v", held.Error)
+	}
+}
+
+func TestErrorArmUsesTheMessage(t *testing.T) {
+	var result Value
+	out, _ := captureOutput(t, func() {
+		result = run(t, `
+def r: Result[Integer, String] = Error("bad")
+match r {
+    Ok(v) -> { println(v) }
+    Error(e) -> { println(e) }
+}
+println("after")`)
+	})
+	if _, failed := result.(*ErrorValue); failed || out != "bad\nafter\n" {
+		t.Fatalf("got %s, stdout %q", show(result), out)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	old := stdin
+	stdin = strings.NewReader("first line\nsecond line\n")
+	defer func() { stdin = old }()
+	if got, want := show(run(t, "readAll()")), "first line\nsecond line\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTruthiness(t *testing.T) {
+	cases := []struct {
+		value Value
+		want  bool
+	}{
+		{&FloatValue{Value: 0}, false},
+		{&FloatValue{Value: 0.5}, true},
+		{&MapValue{Pairs: map[string]Value{}}, false},
+		{&MapValue{Pairs: map[string]Value{"a": &IntegerValue{Value: 1}}}, true},
+		{&BytesValue{}, false},
+		{&BytesValue{Value: []byte{0}}, true},
+		{&ResultValue{IsOk: false, Error: &ErrorValue{Message: "bad"}}, false},
+		{&ResultValue{IsOk: true, Value: &IntegerValue{Value: 0}}, true},
+		{&StructValue{Definition: &StructDefinition{Name: "P"}, Fields: map[string]Value{}}, true},
+	}
+	for _, tc := range cases {
+		if got := IsTruthy(tc.value); got != tc.want {
+			t.Errorf("IsTruthy(%s %s) = %v, want %v", tc.value.Type(), tc.value.String(), got, tc.want)
+		}
+	}
+
+	runEvalCases(t, []evalCase{
+		{"zero float condition", `if 0.0 { "yes" } else { "no" }`, "no"},
+		{"empty map condition", `if {} { "yes" } else { "no" }`, "no"},
+		{"error result condition", `
+def r: Result[Integer, String] = Error("bad")
+if r { "yes" } else { "no" }`, "no"},
+	})
+}
+
+func TestTrace(t *testing.T) {
+	var trace bytes.Buffer
+	opts := DefaultOptions()
+	opts.Trace = &trace
+	RunSandboxed("def x = 1 + 2\nx", opts)
+	for _, want := range []string{"[line ?] Program\n", "[line 1] DefStatement\n", "[line 1] InfixExpression\n", "[line 2] Identifier\n"} {
+		if !strings.Contains(trace.String(), want) {
+			t.Errorf("trace is missing %q:\n%s", want, trace.String())
+		}
+	}
+}
+
+func TestMemoizeRunsOncePerArgument(t *testing.T) {
+	result := run(t, `
+def calls = Mutable(0)
+fun slowDouble(n: Integer) -> Integer {
+    calls == calls + 1
+    return n * 2
+}
+def double = memoize(slowDouble)
+def r: Result[Integer, String] = Ok(2)
+def results = [
+    str([1, 2, 1, 2].map(double)),
+    str([1, 2].filter(double)),
+    str([1].find(double)),
+    str([1, 2].reduce(memoize({ acc, n -> acc + double(n) }), 0)),
+    str(Some(1).map(double)),
+    str(r.map(double)),
+    str(r.then(double)),
+    str(double(3) + double(3))
+]
+(results, calls)`)
+	want := "([[2, 4, 2, 4], [1, 2], Some(1), 6, Some(2), Ok(4), Ok(4), 12], 3)"
+	if got := show(result); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
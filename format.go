@@ -0,0 +1,742 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// indentUnit is one level of indentation in formatted source
+const indentUnit = "    "
+
+// operatorPrecedence gives the binding strength of each infix operator, using
+// the parser's precedence levels
+var operatorPrecedence = map[string]int{
+	"==":  ASSIGN_PREC,
+	"??":  COALESCE_PREC,
+	"or":  OR_PREC,
+	"xor": OR_PREC,
+	"and": AND_PREC,
+	"is":  IS_PREC,
+	">":   COMPARE_PREC,
+	"<":   COMPARE_PREC,
+	">=":  COMPARE_PREC,
+	"<=":  COMPARE_PREC,
+	"+":   SUM_PREC,
+	"-":   SUM_PREC,
+	"*":   PRODUCT_PREC,
+	"/":   PRODUCT_PREC,
+	"%":   PRODUCT_PREC,
+}
+
+// ParseErrors is returned by Format when the source doesn't parse
+type ParseErrors struct {
+	Errors []string
+}
+
+func (e *ParseErrors) Error() string {
+	return "Parse error: " + strings.Join(e.Errors, "\nParse error: ")
+}
+
+// Format parses source and prints it back in canonical layout: four-space
+// indentation, one statement per line, single spaces around operators and at
+// most one blank line between statements. Comments are kept.
+func Format(source string) (string, error) {
+	lexer := NewLexer(source)
+	parser := NewParser(lexer)
+	program := parser.ParseProgram()
+	if len(parser.Errors()) > 0 {
+		return "", &ParseErrors{Errors: parser.Errors()}
+	}
+
+	f := &formatter{
+		out:      &strings.Builder{},
+		comments: lexer.Comments(),
+		blank:    blankLines(source),
+	}
+	f.statementList(program.Statements)
+	f.leadingComments(math.MaxInt)
+	return f.out.String(), nil
+}
+
+// blankLines reports which source lines hold nothing but whitespace
+func blankLines(source string) map[int]bool {
+	blank := make(map[int]bool)
+	for i, line := range strings.Split(source, "\n") {
+		if strings.TrimSpace(line) == "" {
+			blank[i+1] = true
+		}
+	}
+	return blank
+}
+
+type formatter struct {
+	out      *strings.Builder
+	indent   int
+	comments []Comment
+	next     int          // index of the first comment not yet written
+	blank    map[int]bool // source lines that are empty
+	lastLine int          // source line of the last thing written
+	atStart  bool         // nothing written yet in the current block
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+}
+
+func (f *formatter) writeIndent() {
+	f.write(strings.Repeat(indentUnit, f.indent))
+}
+
+// render runs write against a scratch buffer and returns what it wrote,
+// leaving the output and the pending comments as they were
+func (f *formatter) render(write func()) string {
+	out, next, lastLine := f.out, f.next, f.lastLine
+	f.out = &strings.Builder{}
+	write()
+	text := f.out.String()
+	f.out, f.next, f.lastLine = out, next, lastLine
+	return text
+}
+
+// separate writes a blank line before an item at line when the source had
+// one there, except at the start of a block
+func (f *formatter) separate(line int) {
+	if !f.atStart && f.blank[line-1] {
+		f.write("\n")
+	}
+	f.atStart = false
+}
+
+// leadingComments writes the comments that come before line, each on its
+// own line at the current indentation
+func (f *formatter) leadingComments(line int) {
+	for f.next < len(f.comments) && f.comments[f.next].Line < line {
+		c := f.comments[f.next]
+		f.separate(c.Line)
+		f.writeIndent()
+		f.write(c.Text + "\n")
+		f.lastLine = c.Line
+		f.next++
+	}
+}
+
+// trailingComment writes a comment that followed code on the last line
+// written, keeping it on the same line
+func (f *formatter) trailingComment() {
+	if f.next < len(f.comments) {
+		c := f.comments[f.next]
+		if c.Trailing && c.Line == f.lastLine {
+			f.write(" " + c.Text)
+			f.next++
+		}
+	}
+}
+
+// hasComments reports whether any unwritten comment lies within lines
+// from..to
+func (f *formatter) hasComments(from, to int) bool {
+	for _, c := range f.comments[f.next:] {
+		if c.Line >= from && c.Line <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// line starts a new line for an item that began at source line start
+func (f *formatter) line(start int) {
+	f.leadingComments(start)
+	f.separate(start)
+	f.writeIndent()
+	f.lastLine = start
+}
+
+// endLine finishes the current line, keeping a trailing comment
+func (f *formatter) endLine() {
+	f.trailingComment()
+	f.write("\n")
+}
+
+func (f *formatter) statementList(stmts []Statement) {
+	f.atStart = true
+	for _, stmt := range stmts {
+		f.line(startToken(stmt).Line)
+		f.statement(stmt)
+		f.endLine()
+	}
+}
+
+// block writes { statements } with the statements indented one level
+func (f *formatter) block(block *BlockStatement) {
+	if len(block.Statements) == 0 && !f.hasComments(block.Token.Line, block.End.Line) {
+		f.write("{}")
+		f.lastLine = block.End.Line
+		return
+	}
+
+	f.write("{")
+	f.lastLine = block.Token.Line
+	f.endLine()
+	f.indent++
+	f.statementList(block.Statements)
+	f.leadingComments(block.End.Line)
+	f.indent--
+	f.writeIndent()
+	f.write("}")
+	f.lastLine = block.End.Line
+}
+
+// inlineBody returns the text of a block's only statement when it fits on
+// one line, or "" when the block must be written out in full. isExpr reports
+// whether that statement is an expression rather than a jump such as break.
+func (f *formatter) inlineBody(block *BlockStatement) (text string, isExpr bool) {
+	if block == nil || len(block.Statements) != 1 || f.hasComments(block.Token.Line, block.End.Line) {
+		return "", false
+	}
+	switch stmt := block.Statements[0].(type) {
+	case *ExpressionStatement:
+		if stmt.Expression == nil {
+			return "", false
+		}
+		text = f.render(func() { f.expr(stmt.Expression, LOWEST) })
+		isExpr = true
+	case *ReturnStatement, *BreakStatement, *ContinueStatement:
+		text = f.render(func() { f.statement(stmt) })
+	default:
+		return "", false
+	}
+	if strings.Contains(text, "\n") {
+		return "", false
+	}
+	return text, isExpr
+}
+
+func (f *formatter) statement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *DefStatement:
+		f.write("def ")
+		switch {
+		case s.Name != nil:
+			f.write(s.Name.Value)
+		case s.ListPattern:
+			f.write("[" + identifierList(s.Targets) + "]")
+		default:
+			f.write("(" + identifierList(s.Targets) + ")")
+		}
+		if s.TypeHint != nil {
+			f.write(": " + s.TypeHint.String())
+		}
+		f.write(" = ")
+		f.expr(s.Value, LOWEST)
+	case *FunctionStatement:
+		f.function(s)
+	case *ReturnStatement:
+		f.write("return")
+		if s.Value != nil {
+			f.write(" ")
+			f.expr(s.Value, LOWEST)
+		}
+	case *ExpressionStatement:
+		if ifExpr, ok := s.Expression.(*IfExpression); ok {
+			f.ifBlocks(ifExpr)
+		} else if s.Expression != nil {
+			f.expr(s.Expression, LOWEST)
+		}
+	case *WhileStatement:
+		f.label(s.Label)
+		f.write("while ")
+		f.expr(s.Condition, LOWEST)
+		f.write(" ")
+		f.block(s.Body)
+		if s.Else != nil {
+			f.write(" else ")
+			f.block(s.Else)
+		}
+	case *ForStatement:
+		f.label(s.Label)
+		f.write("for ")
+		if s.Index != nil {
+			f.write(s.Index.Value + ", ")
+		}
+		f.write(s.Variable.Value + " in ")
+		f.expr(s.Iterable, LOWEST)
+		f.write(" ")
+		f.block(s.Body)
+	case *RepeatStatement:
+		f.label(s.Label)
+		f.write("repeat ")
+		f.block(s.Body)
+		f.write(" until ")
+		f.expr(s.Condition, LOWEST)
+	case *GuardStatement:
+		f.write("guard ")
+		f.expr(s.Condition, LOWEST)
+		f.write(" else ")
+		f.block(s.Else)
+	case *BreakStatement:
+		f.write("break")
+		if s.Label != nil {
+			f.write(" " + s.Label.Value)
+		}
+	case *ContinueStatement:
+		f.write("continue")
+		if s.Label != nil {
+			f.write(" " + s.Label.Value)
+		}
+	case *StructStatement:
+		f.structStatement(s)
+	case *TypeAliasStatement:
+		f.write("type " + s.Name.Value + " = " + s.Value.String())
+	case *ExtendStatement:
+		f.write("extend " + s.TypeName.Value + " ")
+		f.members(s.Token.Line, s.Methods)
+	case *InterfaceStatement:
+		f.write("interface " + s.Name.Value + " ")
+		f.members(s.Token.Line, s.Methods)
+	case *EnumStatement:
+		f.enumStatement(s)
+	case *ImportStatement:
+		f.write("import " + strings.Join(s.Path, "."))
+	case *BlockStatement:
+		f.block(s)
+	default:
+		f.write(stmt.String())
+	}
+}
+
+func (f *formatter) label(label *Identifier) {
+	if label != nil {
+		f.write(label.Value + ": ")
+	}
+}
+
+func identifierList(idents []*Identifier) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Value
+	}
+	return strings.Join(names, ", ")
+}
+
+// function writes a function statement; interface methods have no body
+func (f *formatter) function(s *FunctionStatement) {
+	f.write("fun " + s.Name.Value)
+	if len(s.TypeParams) > 0 {
+		f.write("[" + identifierList(s.TypeParams) + "]")
+	}
+	params := make([]string, len(s.Parameters))
+	for i, p := range s.Parameters {
+		params[i] = p.Name.Value
+		if p.TypeHint != nil {
+			params[i] += ": " + p.TypeHint.String()
+		}
+	}
+	f.write("(" + strings.Join(params, ", ") + ")")
+	if s.ReturnType != nil {
+		f.write(" -> " + s.ReturnType.String())
+	}
+	if s.Body != nil {
+		f.write(" ")
+		f.block(s.Body)
+	}
+}
+
+// members writes the methods of an extend or interface declaration
+func (f *formatter) members(line int, methods []*FunctionStatement) {
+	f.write("{")
+	f.lastLine = line
+	f.endLine()
+	f.indent++
+	stmts := make([]Statement, len(methods))
+	for i, m := range methods {
+		stmts[i] = m
+	}
+	f.statementList(stmts)
+	f.indent--
+	f.writeIndent()
+	f.write("}")
+}
+
+func (f *formatter) structStatement(s *StructStatement) {
+	f.write("struct " + s.Name.Value + " {")
+	f.lastLine = s.Token.Line
+	f.endLine()
+	f.indent++
+	f.atStart = true
+	for i, field := range s.Fields {
+		f.line(field.Name.Token.Line)
+		f.write(field.Name.Value)
+		if field.TypeHint != nil {
+			f.write(": " + field.TypeHint.String())
+		}
+		if i < len(s.Fields)-1 {
+			f.write(",")
+		}
+		f.endLine()
+	}
+	f.indent--
+	f.writeIndent()
+	f.write("}")
+}
+
+// enumStatement writes an enum with only plain variants on one line, and one
+// with payloads a variant per line
+func (f *formatter) enumStatement(s *EnumStatement) {
+	f.write("enum " + s.Name.Value + " {")
+	multiline := false
+	for _, v := range s.Variants {
+		if len(v.Fields) > 0 || f.hasComments(s.Token.Line, v.Name.Token.Line) {
+			multiline = true
+		}
+	}
+
+	if !multiline {
+		names := make([]string, len(s.Variants))
+		for i, v := range s.Variants {
+			names[i] = v.Name.Value
+		}
+		f.write(" " + strings.Join(names, ", ") + " }")
+		return
+	}
+
+	f.lastLine = s.Token.Line
+	f.endLine()
+	f.indent++
+	f.atStart = true
+	for i, v := range s.Variants {
+		f.line(v.Name.Token.Line)
+		f.write(v.Name.Value)
+		if len(v.Fields) > 0 {
+			fields := make([]string, len(v.Fields))
+			for j, field := range v.Fields {
+				fields[j] = field.String()
+			}
+			f.write("(" + strings.Join(fields, ", ") + ")")
+		}
+		if i < len(s.Variants)-1 {
+			f.write(",")
+		}
+		f.endLine()
+	}
+	f.indent--
+	f.writeIndent()
+	f.write("}")
+}
+
+// ifBlocks writes an if with its blocks laid out over several lines
+func (f *formatter) ifBlocks(e *IfExpression) {
+	f.write("if ")
+	f.expr(e.Condition, LOWEST)
+	f.write(" ")
+	f.block(e.Consequence)
+	if e.Alternative != nil {
+		f.write(" else ")
+		f.block(e.Alternative)
+	}
+}
+
+// expr writes an expression, wrapping it in parentheses when it binds more
+// loosely than prec, the precedence its position requires
+func (f *formatter) expr(e Expression, prec int) {
+	switch e := e.(type) {
+	case *InfixExpression:
+		p := operatorPrecedence[e.Operator]
+		if p < prec {
+			f.parenthesized(e)
+			return
+		}
+		// Operators associate to the left, so only the right operand needs
+		// parentheses at the same level
+		f.expr(e.Left, p)
+		f.write(" " + e.Operator + " ")
+		f.expr(e.Right, p+1)
+	case *AssignmentExpression:
+		if ASSIGN_PREC < prec {
+			f.parenthesized(e)
+			return
+		}
+		f.write(e.Name.Value + " == ")
+		f.expr(e.Value, LOWEST)
+	case *PrefixExpression:
+		if PREFIX_PREC < prec {
+			f.parenthesized(e)
+			return
+		}
+		if e.Operator == "not" {
+			f.write("not ")
+		} else {
+			f.write(e.Operator)
+		}
+		f.expr(e.Right, PREFIX_PREC)
+	case *CallExpression:
+		f.expr(e.Function, CALL_PREC)
+		f.write("(")
+		f.exprList(e.Arguments)
+		f.write(")")
+	case *MemberExpression:
+		f.expr(e.Object, CALL_PREC)
+		if e.Optional {
+			f.write("?")
+		}
+		f.write("." + e.Member.Value)
+	case *IndexExpression:
+		f.expr(e.Left, CALL_PREC)
+		f.write("[")
+		f.expr(e.Index, LOWEST)
+		f.write("]")
+	case *Identifier:
+		f.write(e.Value)
+	case *IntegerLiteral:
+		f.write(e.Token.Literal)
+	case *FloatLiteral:
+		f.write(e.Token.Literal)
+	case *StringLiteral:
+		f.write(quoteString(e.Value))
+	case *BooleanLiteral:
+		f.write(e.Token.Literal)
+	case *ListLiteral:
+		f.write("[")
+		f.exprList(e.Elements)
+		f.write("]")
+	case *TupleLiteral:
+		f.write("(")
+		f.exprList(e.Elements)
+		if len(e.Elements) == 1 {
+			f.write(",")
+		}
+		f.write(")")
+	case *MapLiteral:
+		f.write("{")
+		for i, pair := range e.Pairs {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expr(pair.Key, LOWEST)
+			f.write(": ")
+			f.expr(pair.Value, LOWEST)
+		}
+		f.write("}")
+	case *StructLiteral:
+		f.write(e.StructName.Value + " {")
+		var entries []string
+		for _, spread := range e.Spreads {
+			entries = append(entries, "..."+f.render(func() { f.expr(spread, LOWEST) }))
+		}
+		entries = append(entries, f.fields(e.Fields)...)
+		if len(entries) > 0 {
+			f.write(" " + strings.Join(entries, ", ") + " ")
+		}
+		f.write("}")
+	case *WithExpression:
+		f.expr(e.Object, CALL_PREC)
+		f.write(".with { " + strings.Join(f.fields(e.Updates), ", ") + " }")
+	case *FunctionLiteral:
+		f.write("{ " + identifierList(e.Parameters) + " -> ")
+		f.expr(e.Body, LOWEST)
+		f.write(" }")
+	case *IfExpression:
+		f.ifExpr(e)
+	case *MatchExpression:
+		f.match(e)
+	case *OptionExpression:
+		if !e.IsSome {
+			f.write("None")
+			return
+		}
+		f.write("Some(")
+		f.expr(e.Value, LOWEST)
+		f.write(")")
+	case *ResultExpression:
+		if e.IsOk {
+			f.write("Ok(")
+		} else {
+			f.write("Error(")
+		}
+		f.expr(e.Value, LOWEST)
+		f.write(")")
+	case *MutableExpression:
+		f.write("Mutable")
+		if e.TypeHint != nil {
+			f.write("[" + e.TypeHint.String() + "]")
+		}
+		f.write("(")
+		f.expr(e.Value, LOWEST)
+		f.write(")")
+	case nil:
+	default:
+		f.write(e.String())
+	}
+}
+
+func (f *formatter) parenthesized(e Expression) {
+	f.write("(")
+	f.expr(e, LOWEST)
+	f.write(")")
+}
+
+func (f *formatter) exprList(exprs []Expression) {
+	for i, e := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expr(e, LOWEST)
+	}
+}
+
+// fields renders the name: value entries of a struct literal or with
+// expression in source order
+func (f *formatter) fields(fields map[string]Expression) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := startToken(fields[names[i]]), startToken(fields[names[j]])
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	entries := make([]string, len(names))
+	for i, name := range names {
+		key := name
+		if !isIdentifierName(name) {
+			key = quoteString(name)
+		}
+		entries[i] = key + ": " + f.render(func() { f.expr(fields[name], LOWEST) })
+	}
+	return entries
+}
+
+// ifExpr writes an if used as a value on one line when each branch is a
+// single expression, and over several lines otherwise
+func (f *formatter) ifExpr(e *IfExpression) {
+	consequence, _ := f.inlineBody(e.Consequence)
+	alternative, _ := f.inlineBody(e.Alternative)
+	if consequence == "" || (e.Alternative != nil && alternative == "") {
+		f.ifBlocks(e)
+		return
+	}
+	f.write("if ")
+	f.expr(e.Condition, LOWEST)
+	f.write(" { " + consequence + " }")
+	if e.Alternative != nil {
+		f.write(" else { " + alternative + " }")
+	}
+}
+
+// match writes a match with one case per line. A case whose body is a single
+// expression is written after the arrow without braces.
+func (f *formatter) match(e *MatchExpression) {
+	f.write("match ")
+	f.expr(e.Value, LOWEST)
+	f.write(" {")
+	f.lastLine = e.Token.Line
+	f.endLine()
+	f.indent++
+	f.atStart = true
+	for _, c := range e.Cases {
+		f.line(startToken(c.Pattern).Line)
+		f.expr(c.Pattern, LOWEST)
+		for _, alt := range c.Alternatives {
+			f.write(", ")
+			f.expr(alt, LOWEST)
+		}
+		f.write(" -> ")
+		// A body starting with { would be read as a block
+		body, isExpr := f.inlineBody(c.Body)
+		switch {
+		case body == "":
+			f.block(c.Body)
+		case isExpr && !strings.HasPrefix(body, "{"):
+			f.write(body)
+		default:
+			f.write("{ " + body + " }")
+		}
+		f.endLine()
+	}
+	f.indent--
+	f.writeIndent()
+	f.write("}")
+}
+
+// startToken returns the first token of a node. Infix and postfix nodes
+// keep their operator token, so look through to the leftmost operand.
+func startToken(node Node) Token {
+	switch n := node.(type) {
+	case *InfixExpression:
+		return startToken(n.Left)
+	case *CallExpression:
+		return startToken(n.Function)
+	case *MemberExpression:
+		return startToken(n.Object)
+	case *IndexExpression:
+		return startToken(n.Left)
+	case *WithExpression:
+		return startToken(n.Object)
+	case *AssignmentExpression:
+		return n.Name.Token
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return startToken(n.Expression)
+		}
+	case *WhileStatement:
+		if n.Label != nil {
+			return n.Label.Token
+		}
+	case *ForStatement:
+		if n.Label != nil {
+			return n.Label.Token
+		}
+	case *RepeatStatement:
+		if n.Label != nil {
+			return n.Label.Token
+		}
+	}
+	tok, _ := nodeToken(node)
+	return tok
+}
+
+func isIdentifierName(s string) bool {
+	if s == "" || !isLetter(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return LookupIdent(s) == IDENT
+}
+
+// quoteString returns a string literal that lexes back to s. Escapes are
+// kept verbatim in string values, so the only choice is which quotes can
+// hold s: "...", r"..." or """...""".
+func quoteString(s string) string {
+	if !strings.Contains(s, "\n") && fitsQuotes(s) {
+		return "\"" + s + "\""
+	}
+	if !strings.ContainsAny(s, "\"\n") {
+		return "r\"" + s + "\""
+	}
+	return "\"\"\"" + s + "\"\"\""
+}
+
+// fitsQuotes reports whether s can sit between plain double quotes: every
+// quote is escaped and no trailing backslash escapes the closing quote
+func fitsQuotes(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return false
+		case '\\':
+			if i+1 == len(s) {
+				return false
+			}
+			i++
+		}
+	}
+	return true
+}
@@ -1,13 +1,29 @@
 package main
 
+import "strings"
+
 // Lexer tokenizes MoonShot source code
 type Lexer struct {
-	input   string
-	pos     int  // current position in input
-	readPos int  // current reading position (after current char)
-	ch      byte // current char under examination
-	line    int  // current line number
-	column  int  // current column number
+	input    string
+	pos      int  // current position in input
+	readPos  int  // current reading position (after current char)
+	ch       byte // current char under examination
+	line     int  // current line number
+	column   int  // current column number
+	comments []Comment
+}
+
+// Comment is a // comment. The parser ignores comments, but the lexer keeps
+// them so the formatter can put them back.
+type Comment struct {
+	Line     int
+	Text     string // including the leading //
+	Trailing bool   // follows code on the same line
+}
+
+// Comments returns the comments read so far, in source order
+func (l *Lexer) Comments() []Comment {
+	return l.comments
 }
 
 // NewLexer creates a new Lexer
@@ -35,6 +51,14 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 }
 
+// peekCharAt looks n characters past the current one
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.pos+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n]
+}
+
 // NextToken returns the next token from the input
 func (l *Lexer) NextToken() Token {
 	l.skipWhitespaceExceptNewline()
@@ -88,6 +112,8 @@ func (l *Lexer) NextToken() Token {
 		} else {
 			tok = l.newToken(LT, string(l.ch))
 		}
+	case '|':
+		tok = l.newToken(PIPE, string(l.ch))
 	case '(':
 		tok = l.newToken(LPAREN, string(l.ch))
 	case ')':
@@ -105,15 +131,38 @@ func (l *Lexer) NextToken() Token {
 	case ':':
 		tok = l.newToken(COLON, string(l.ch))
 	case '.':
-		tok = l.newToken(DOT, string(l.ch))
+		if l.peekChar() == '.' && l.peekCharAt(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = Token{Type: ELLIPSIS, Literal: "...", Line: tok.Line, Column: tok.Column}
+		} else {
+			tok = l.newToken(DOT, string(l.ch))
+		}
+	case '?':
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = Token{Type: QUESTION_DOT, Literal: "?.", Line: tok.Line, Column: tok.Column}
+		} else if l.peekChar() == '?' {
+			l.readChar()
+			tok = Token{Type: COALESCE, Literal: "??", Line: tok.Line, Column: tok.Column}
+		} else {
+			tok = l.newToken(ILLEGAL, string(l.ch))
+		}
 	case '"':
 		tok.Type = STRING
-		tok.Literal = l.readString()
+		if l.peekChar() == '"' && l.peekCharAt(2) == '"' {
+			tok.Literal = l.readTripleString()
+		} else {
+			tok.Literal = l.readString()
+		}
 	case 0:
 		tok.Type = EOF
 		tok.Literal = ""
 	default:
-		if isLetter(l.ch) {
+		if l.ch == 'r' && l.peekChar() == '"' {
+			tok.Type = STRING
+			tok.Literal = l.readRawString()
+		} else if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = LookupIdent(tok.Literal)
 			return tok
@@ -175,6 +224,41 @@ func (l *Lexer) readString() string {
 	return l.input[pos:l.pos]
 }
 
+// readRawString reads an r"..." string, where backslashes are literal
+// and the first quote ends the string
+func (l *Lexer) readRawString() string {
+	l.readChar() // skip r
+	l.readChar() // skip opening quote
+	pos := l.pos
+
+	for l.ch != '"' && l.ch != 0 {
+		l.readChar()
+	}
+
+	return l.input[pos:l.pos]
+}
+
+// readTripleString reads a """...""" string verbatim, newlines included
+func (l *Lexer) readTripleString() string {
+	l.readChar() // skip the three opening quotes
+	l.readChar()
+	l.readChar()
+	pos := l.pos
+
+	for l.ch != 0 && !(l.ch == '"' && l.peekChar() == '"' && l.peekCharAt(2) == '"') {
+		if l.ch == '\n' {
+			l.line++
+			l.column = 0
+		}
+		l.readChar()
+	}
+
+	literal := l.input[pos:l.pos]
+	l.readChar() // skip two closing quotes; NextToken consumes the last
+	l.readChar()
+	return literal
+}
+
 func (l *Lexer) skipWhitespaceExceptNewline() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' {
 		l.readChar()
@@ -182,9 +266,16 @@ func (l *Lexer) skipWhitespaceExceptNewline() {
 }
 
 func (l *Lexer) skipComment() {
+	start := l.pos
 	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+	lineStart := strings.LastIndexByte(l.input[:start], '\n') + 1
+	l.comments = append(l.comments, Comment{
+		Line:     l.line,
+		Text:     strings.TrimRight(l.input[start:l.pos], " \t\r"),
+		Trailing: strings.TrimSpace(l.input[lineStart:start]) != "",
+	})
 }
 
 func isLetter(ch byte) bool {
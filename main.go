@@ -3,28 +3,56 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	opts := DefaultOptions()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "fmt" {
+		os.Exit(runFmt(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "test" {
+		os.Exit(runTest(args[1:]))
+	}
+options:
+	for len(args) > 0 {
+		switch args[0] {
+		case "-w":
+			opts.WarnShadowing = true
+		case "--trace":
+			opts.Trace = os.Stderr
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+
+	if len(args) < 1 {
 		fmt.Println("MoonShot Language Interpreter")
-		fmt.Println("Usage: moonshot <file.moon>")
-		fmt.Println("       moonshot -e <expression>")
+		fmt.Println("Usage: moonshot [-w] [--trace] <file.moon>")
+		fmt.Println("       moonshot [-w] [--trace] -e <expression>")
+		fmt.Println("       moonshot fmt [-w] <file.moon>")
+		fmt.Println("       moonshot test <file.moon>")
+		fmt.Println("  -w       warn when a def shadows an outer name")
+		fmt.Println("  --trace  print each evaluated node and its line to stderr")
+		fmt.Println("  fmt      print the file in canonical layout (-w rewrites it)")
+		fmt.Println("  test     run the file, report assertEqual/assertTrue failures and counts")
 		os.Exit(0)
 	}
 
 	var source string
 	var filename string
 
-	if os.Args[1] == "-e" {
-		if len(os.Args) < 3 {
+	if args[0] == "-e" {
+		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "Error: -e requires an expression")
 			os.Exit(1)
 		}
-		source = os.Args[2]
+		source = args[1]
 		filename = "<eval>"
 	} else {
-		filename = os.Args[1]
+		filename = args[0]
 		content, err := os.ReadFile(filename)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
@@ -33,39 +61,172 @@ func main() {
 		source = string(content)
 	}
 
-	result := Run(source, filename)
-	if result != nil {
-		if errVal, ok := result.(*ErrorValue); ok {
-			fmt.Fprintln(os.Stderr, errVal.String())
-			os.Exit(1)
+	result := RunWithOptions(source, filename, opts)
+	switch r := result.(type) {
+	case *ErrorValue:
+		fmt.Fprintln(os.Stderr, FormatError(r))
+		os.Exit(1)
+	case *ExitValue:
+		os.Exit(r.Code)
+	}
+}
+
+// runFmt implements the fmt subcommand and returns the exit code
+func runFmt(args []string) int {
+	write := false
+	if len(args) > 0 && args[0] == "-w" {
+		write = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: moonshot fmt [-w] <file.moon>")
+		return 2
+	}
+
+	filename := args[0]
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+		return 1
+	}
+	formatted, err := Format(string(content))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !write {
+		fmt.Print(formatted)
+		return 0
+	}
+	if formatted == string(content) {
+		return 0
+	}
+	if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runTest implements the test subcommand: the file runs to the end with
+// failed assertions collected, then a summary is printed. The exit code is 1
+// when an assertion failed or the program stopped with an error, and the
+// program's own code when it called exit with a non-zero one.
+func runTest(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: moonshot test <file.moon>")
+		return 2
+	}
+
+	filename := args[0]
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+		return 1
+	}
+
+	results, result := RunTests(string(content), filename, DefaultOptions())
+	for _, failure := range results.Failures {
+		fmt.Printf("FAIL %s\n", strings.ReplaceAll(FormatError(failure), "\n", "\n     "))
+	}
+	code := 0
+	if len(results.Failures) > 0 {
+		code = 1
+	}
+	switch r := result.(type) {
+	case *ErrorValue:
+		fmt.Fprintln(os.Stderr, FormatError(r))
+		code = 1
+	case *ExitValue:
+		// A non-zero exit fails the run like it would outside test mode
+		if r.Code != 0 {
+			fmt.Fprintf(os.Stderr, "exited with code %d\n", r.Code)
+			code = r.Code
 		}
 	}
+	fmt.Printf("%d passed, %d failed\n", results.Passed, len(results.Failures))
+	return code
 }
 
-// Run executes MoonShot source code
+// Run executes MoonShot source code with default options
 func Run(source string, filename string) Value {
+	return RunWithOptions(source, filename, DefaultOptions())
+}
+
+// RunWithOptions executes MoonShot source code with the given options
+func RunWithOptions(source string, filename string, opts Options) Value {
+	program, err := checkProgram(source, opts)
+	if err != nil {
+		return err
+	}
+
+	env := NewEnvironment()
+	RegisterBuiltinsWithOptions(env, opts)
+	evaluator := NewEvaluatorWithOptions(opts)
+
+	return evaluator.Eval(program, env)
+}
+
+// RunTests executes source in test mode: failed assertions are collected
+// instead of stopping the program
+func RunTests(source string, filename string, opts Options) (*TestResults, Value) {
+	results := &TestResults{}
+	program, err := checkProgram(source, opts)
+	if err != nil {
+		return results, err
+	}
+
+	env := NewEnvironment()
+	RegisterBuiltinsWithOptions(env, opts)
+	evaluator := NewEvaluatorWithOptions(opts)
+	evaluator.tests = results
+
+	return results, evaluator.Eval(program, env)
+}
+
+// checkProgram parses and type checks source, printing any errors and
+// warnings to stderr unless opts.Quiet is set
+func checkProgram(source string, opts Options) (*Program, Value) {
 	lexer := NewLexer(source)
 	parser := NewParser(lexer)
 	program := parser.ParseProgram()
 
 	if len(parser.Errors()) > 0 {
+		if opts.Quiet {
+			return nil, &ErrorValue{Message: "parse errors:\n" + strings.Join(parser.Errors(), "\n")}
+		}
 		for _, err := range parser.Errors() {
 			fmt.Fprintf(os.Stderr, "Parse error: %s\n", err)
 		}
-		return &ErrorValue{Message: "Parse errors occurred"}
+		return nil, &ErrorValue{Message: "Parse errors occurred"}
 	}
 
 	// Type check
 	checker := NewTypeChecker()
-	if err := checker.Check(program); err != nil {
+	checker.registerHostFunctions(opts)
+	checker.WarnShadowing = opts.WarnShadowing
+	err := checker.Check(program)
+	if opts.Quiet {
+		if err != nil {
+			return nil, &ErrorValue{Message: err.Error()}
+		}
+		return program, nil
+	}
+	for _, warning := range checker.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Type error: %s\n", err)
-		return &ErrorValue{Message: err.Error()}
+		return nil, &ErrorValue{Message: err.Error()}
 	}
+	return program, nil
+}
 
-	// Evaluate
-	env := NewEnvironment()
-	RegisterBuiltins(env)
-	evaluator := NewEvaluator()
-
-	return evaluator.Eval(program, env)
+// RunSandboxed executes source in a fresh evaluator and environment so that
+// nothing defined by one run is visible to the next. Parse and type errors are
+// returned as an *ErrorValue instead of being printed.
+func RunSandboxed(source string, opts Options) Value {
+	opts.Quiet = true
+	return RunWithOptions(source, "<sandbox>", opts)
 }
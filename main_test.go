@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the named files in a temporary directory and makes it
+// the working directory, where imports are resolved, for the rest of the test
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return dir
+}
+
+func TestModuleEvaluatedOnce(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"shared.moon": "println(\"loading shared\")\ndef value = 1\n",
+		"a.moon":      "import shared\ndef a = shared.value\n",
+		"b.moon":      "import shared\ndef b = shared.value + 1\n",
+	})
+	var result Value
+	out, _ := captureOutput(t, func() {
+		result = run(t, "import a\nimport b\nimport shared\na.a + b.b + shared.value")
+	})
+	if got := show(result); got != "4" {
+		t.Fatalf("result = %s", got)
+	}
+	if strings.Count(out, "loading shared") != 1 {
+		t.Fatalf("shared ran %d times, output %q", strings.Count(out, "loading shared"), out)
+	}
+}
+
+func TestImportCycle(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"x.moon": "import y\ndef xv = 1\n",
+		"y.moon": "import x\ndef yv = 2\n",
+	})
+	if got := show(run(t, "import x\nx.xv")); got != "error: import cycle: x -> y -> x" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestModuleExports(t *testing.T) {
+	writeFiles(t, map[string]string{
+		"geometry.moon": "def pi = 3.14\ndef _scale = 2\nfun area(r: Float) -> Float {\n    return pi * r * r\n}\n",
+	})
+	if got := show(run(t, "import geometry\ngeometry.exports()")); got != "[area, pi]" {
+		t.Fatalf("got %s", got)
+	}
+	if got := show(run(t, "import time\ntime.exports()")); got != "[format, now, sleep]" {
+		t.Fatalf("built-in module: got %s", got)
+	}
+}
+
+func TestRunTestsReportsFailures(t *testing.T) {
+	results, result := RunTests(`assertEqual(1 + 1, 2)
+assertEqual("a", "b")
+assertTrue(1 > 2)
+assertTrue(2 > 1)`, "t.moon", DefaultOptions())
+	if _, ok := result.(*ErrorValue); ok {
+		t.Fatalf("run stopped: %s", show(result))
+	}
+	if results.Passed != 2 || len(results.Failures) != 2 {
+		t.Fatalf("passed %d, failed %d", results.Passed, len(results.Failures))
+	}
+	first := results.Failures[0]
+	if first.Line != 2 || first.Method != "assertEqual" || first.Message != `expected "b", got "a"` {
+		t.Fatalf("first failure = line %d, %s: %s", first.Line, first.Method, first.Message)
+	}
+}
+
+func TestAssertEqualOutsideTestMode(t *testing.T) {
+	if got := show(run(t, "assertEqual(3, 4)\n1")); got != "error: expected 4, got 3" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestRunTestExitCode(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pass.moon": "assertEqual(1, 1)\n",
+		"fail.moon": "assertEqual(1, 2)\n",
+		"exit.moon": "assertEqual(1, 1)\nexit(3)\n",
+		"zero.moon": "assertEqual(1, 1)\nexit(0)\n",
+	})
+	cases := map[string]int{"pass.moon": 0, "fail.moon": 1, "exit.moon": 3, "zero.moon": 0}
+	for name, want := range cases {
+		if got := runTest([]string{filepath.Join(dir, name)}); got != want {
+			t.Errorf("%s: exit code %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	source := "def xs = [1,2,3]\nfun f(n:Integer)->Integer{\nreturn n*2 // double\n}\n"
+	once, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twice, err := Format(once)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if once != twice {
+		t.Fatalf("second format changed the output:\n%s\n---\n%s", once, twice)
+	}
+	if !strings.Contains(once, "def xs = [1, 2, 3]") || !strings.Contains(once, "return n * 2 // double") {
+		t.Fatalf("unexpected layout:\n%s", once)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	parser := NewParser(NewLexer("def x = 1\ndef = 2"))
+	parser.ParseProgram()
+	errs := parser.Errors()
+	if len(errs) == 0 || !strings.HasPrefix(errs[0], "Line 2, Column ") {
+		t.Fatalf("errors = %q", errs)
+	}
+}
+
+func TestRunSandboxedReturnsDiagnostics(t *testing.T) {
+	var result Value
+	errOut := captureStderr(t, func() {
+		result = RunSandboxed("def = 1", DefaultOptions())
+	})
+	if got := show(result); !strings.HasPrefix(got, "error: parse errors:\nLine 1, Column 5") {
+		t.Fatalf("got %q", got)
+	}
+	if errOut != "" {
+		t.Fatalf("printed %q", errOut)
+	}
+	if got := show(RunSandboxed("def x: Integer = \"a\"", DefaultOptions())); !strings.Contains(got, "String") {
+		t.Fatalf("type error: got %q", got)
+	}
+}
+
+// captureStderr runs fn with the process's stderr redirected to a pipe
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stderr
+	os.Stderr = w
+	fn()
+	os.Stderr = old
+	w.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 )
 
@@ -9,35 +10,39 @@ import (
 const (
 	_ int = iota
 	LOWEST
-	ASSIGN_PREC  // ==
-	OR_PREC      // or
-	AND_PREC     // and
-	IS_PREC      // is
-	COMPARE_PREC // >, <, >=, <=
-	SUM_PREC     // +, -
-	PRODUCT_PREC // *, /, %
-	PREFIX_PREC  // not, -
-	CALL_PREC    // .
-	INDEX_PREC   // [
+	ASSIGN_PREC   // ==
+	COALESCE_PREC // ??
+	OR_PREC       // or
+	AND_PREC      // and
+	IS_PREC       // is
+	COMPARE_PREC  // >, <, >=, <=
+	SUM_PREC      // +, -
+	PRODUCT_PREC  // *, /, %
+	PREFIX_PREC   // not, -
+	CALL_PREC     // .
+	INDEX_PREC    // [
 )
 
 var precedences = map[TokenType]int{
-	ASSIGN_MUT: ASSIGN_PREC,
-	OR:         OR_PREC,
-	AND:        AND_PREC,
-	IS:         IS_PREC,
-	GT:         COMPARE_PREC,
-	LT:         COMPARE_PREC,
-	GTE:        COMPARE_PREC,
-	LTE:        COMPARE_PREC,
-	PLUS:       SUM_PREC,
-	MINUS:      SUM_PREC,
-	MULTIPLY:   PRODUCT_PREC,
-	DIVIDE:     PRODUCT_PREC,
-	MODULO:     PRODUCT_PREC,
-	LPAREN:     CALL_PREC,
-	DOT:        CALL_PREC,
-	LBRACKET:   INDEX_PREC,
+	ASSIGN_MUT:   ASSIGN_PREC,
+	COALESCE:     COALESCE_PREC,
+	OR:           OR_PREC,
+	XOR:          OR_PREC,
+	AND:          AND_PREC,
+	IS:           IS_PREC,
+	GT:           COMPARE_PREC,
+	LT:           COMPARE_PREC,
+	GTE:          COMPARE_PREC,
+	LTE:          COMPARE_PREC,
+	PLUS:         SUM_PREC,
+	MINUS:        SUM_PREC,
+	MULTIPLY:     PRODUCT_PREC,
+	DIVIDE:       PRODUCT_PREC,
+	MODULO:       PRODUCT_PREC,
+	LPAREN:       CALL_PREC,
+	DOT:          CALL_PREC,
+	QUESTION_DOT: CALL_PREC,
+	LBRACKET:     INDEX_PREC,
 }
 
 type (
@@ -62,6 +67,8 @@ func NewParser(l *Lexer) *Parser {
 
 	p.prefixParseFns = make(map[TokenType]prefixParseFn)
 	p.registerPrefix(IDENT, p.parseIdentifier)
+	p.registerPrefix(TYPE, p.parseIdentifier)   // the type() builtin
+	p.registerPrefix(REPEAT, p.parseIdentifier) // the repeat() builtin
 	p.registerPrefix(INTEGER, p.parseIntegerLiteral)
 	p.registerPrefix(FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(STRING, p.parseStringLiteral)
@@ -92,9 +99,12 @@ func NewParser(l *Lexer) *Parser {
 	p.registerInfix(LTE, p.parseInfixExpression)
 	p.registerInfix(AND, p.parseInfixExpression)
 	p.registerInfix(OR, p.parseInfixExpression)
+	p.registerInfix(COALESCE, p.parseInfixExpression)
+	p.registerInfix(XOR, p.parseInfixExpression)
 	p.registerInfix(IS, p.parseInfixExpression)
 	p.registerInfix(LPAREN, p.parseCallExpression)
 	p.registerInfix(DOT, p.parseMemberExpression)
+	p.registerInfix(QUESTION_DOT, p.parseMemberExpression)
 	p.registerInfix(LBRACKET, p.parseIndexExpression)
 	p.registerInfix(ASSIGN_MUT, p.parseAssignmentExpression)
 
@@ -122,10 +132,15 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// addError records a parse error at the position of tok
+func (p *Parser) addError(tok Token, format string, args ...interface{}) {
+	err := NewParseError(tok.Line, tok.Column, fmt.Sprintf(format, args...))
+	p.errors = append(p.errors, err.Error())
+}
+
 func (p *Parser) peekError(t TokenType) {
-	msg := fmt.Sprintf("line %d: expected next token to be %s, got %s instead",
-		p.peekToken.Line, t.String(), p.peekToken.Type.String())
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, "expected next token to be %s, got %s instead",
+		t.String(), p.peekToken.Type.String())
 }
 
 func (p *Parser) curTokenIs(t TokenType) bool {
@@ -184,6 +199,13 @@ func (p *Parser) skipNewlines() {
 	}
 }
 
+// skipPeekNewlines advances past newlines so that peekToken is significant
+func (p *Parser) skipPeekNewlines() {
+	for p.peekTokenIs(NEWLINE) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() Statement {
 	switch p.curToken.Type {
 	case DEF:
@@ -198,24 +220,107 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseWhileStatement()
 	case FOR:
 		return p.parseForStatement()
+	case REPEAT:
+		// `repeat` is also the repeat() builtin, so only `repeat {` starts a loop
+		if p.peekTokenIs(LBRACE) {
+			return p.parseRepeatStatement()
+		}
+		return p.parseExpressionStatement()
+	case GUARD:
+		return p.parseGuardStatement()
 	case BREAK:
-		return &BreakStatement{Token: p.curToken}
+		return &BreakStatement{Token: p.curToken, Label: p.parseOptionalLabel()}
 	case CONTINUE:
-		return &ContinueStatement{Token: p.curToken}
+		return &ContinueStatement{Token: p.curToken, Label: p.parseOptionalLabel()}
 	case STRUCT:
 		return p.parseStructStatement()
+	case TYPE:
+		// `type` is also the type() builtin, so only `type Name` starts an alias
+		if p.peekTokenIs(IDENT) {
+			return p.parseTypeAliasStatement()
+		}
+		return p.parseExpressionStatement()
 	case EXTEND:
 		return p.parseExtendStatement()
+	case INTERFACE:
+		return p.parseInterfaceStatement()
+	case ENUM:
+		return p.parseEnumStatement()
 	case IMPORT:
 		return p.parseImportStatement()
 	default:
+		if p.curTokenIs(IDENT) && p.peekTokenIs(COLON) {
+			return p.parseLabeledLoop()
+		}
 		return p.parseExpressionStatement()
 	}
 }
 
+// parseOptionalLabel parses the label after break/continue, if any
+func (p *Parser) parseOptionalLabel() *Identifier {
+	if !p.peekTokenIs(IDENT) {
+		return nil
+	}
+	p.nextToken()
+	return &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+// parseLabeledLoop parses label: followed by a for, while or repeat loop
+func (p *Parser) parseLabeledLoop() Statement {
+	label := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() // consume ':'
+	p.nextToken() // move to loop keyword
+
+	switch p.curToken.Type {
+	case FOR:
+		if stmt := p.parseForStatement(); stmt != nil {
+			stmt.Label = label
+			return stmt
+		}
+		return nil
+	case WHILE:
+		if stmt := p.parseWhileStatement(); stmt != nil {
+			stmt.Label = label
+			return stmt
+		}
+		return nil
+	case REPEAT:
+		if stmt := p.parseRepeatStatement(); stmt != nil {
+			stmt.Label = label
+			return stmt
+		}
+		return nil
+	}
+
+	p.addError(label.Token, "label %s must be followed by a loop", label.Value)
+	return nil
+}
+
 func (p *Parser) parseDefStatement() *DefStatement {
 	stmt := &DefStatement{Token: p.curToken}
 
+	// Destructuring: def (a, b) = ... or def [a, b] = ...
+	if p.peekTokenIs(LPAREN) || p.peekTokenIs(LBRACKET) {
+		p.nextToken()
+		end := RPAREN
+		if p.curTokenIs(LBRACKET) {
+			end = RBRACKET
+			stmt.ListPattern = true
+		}
+		stmt.Targets = p.parseIdentifierList(end)
+		if stmt.Targets == nil {
+			return nil
+		}
+
+		if !p.expectPeek(ASSIGN) {
+			return nil
+		}
+
+		p.nextToken()
+		stmt.Value = p.parseExpression(LOWEST)
+		return stmt
+	}
+
 	if !p.expectPeek(IDENT) {
 		return nil
 	}
@@ -239,7 +344,46 @@ func (p *Parser) parseDefStatement() *DefStatement {
 	return stmt
 }
 
+// parseIdentifierList parses comma-separated identifiers up to the end token
+func (p *Parser) parseIdentifierList(end TokenType) []*Identifier {
+	idents := []*Identifier{}
+
+	for !p.peekTokenIs(end) {
+		if !p.expectPeek(IDENT) {
+			return nil
+		}
+		idents = append(idents, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(end) {
+		return nil
+	}
+
+	return idents
+}
+
 func (p *Parser) parseTypeAnnotation() *TypeAnnotation {
+	ta := p.parseSingleTypeAnnotation()
+
+	// Union types like Integer | String
+	if p.peekTokenIs(PIPE) {
+		union := &TypeAnnotation{Token: ta.Token, Members: []*TypeAnnotation{ta}}
+		for p.peekTokenIs(PIPE) {
+			p.nextToken() // consume '|'
+			p.nextToken() // move to next member
+			union.Members = append(union.Members, p.parseSingleTypeAnnotation())
+		}
+		return union
+	}
+
+	return ta
+}
+
+func (p *Parser) parseSingleTypeAnnotation() *TypeAnnotation {
 	ta := &TypeAnnotation{Token: p.curToken, Name: p.curToken.Literal}
 
 	// Check for type parameters like List[Integer]
@@ -274,6 +418,12 @@ func (p *Parser) parseFunctionStatement() *FunctionStatement {
 
 	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// Optional type parameters: fun identity[T](x: T) -> T
+	if p.peekTokenIs(LBRACKET) {
+		p.nextToken()
+		stmt.TypeParams = p.parseIdentifierList(RBRACKET)
+	}
+
 	if !p.expectPeek(LPAREN) {
 		return nil
 	}
@@ -321,6 +471,10 @@ func (p *Parser) parseFunctionParameters() []*FunctionParameter {
 
 	for p.peekTokenIs(COMMA) {
 		p.nextToken()
+		// Allow a trailing comma before ')'
+		if p.peekTokenIs(RPAREN) {
+			break
+		}
 		p.nextToken()
 
 		param := &FunctionParameter{
@@ -372,6 +526,54 @@ func (p *Parser) parseWhileStatement() *WhileStatement {
 
 	stmt.Body = p.parseBlockStatement()
 
+	if p.peekTokenIs(ELSE) {
+		p.nextToken()
+
+		if !p.expectPeek(LBRACE) {
+			return nil
+		}
+
+		stmt.Else = p.parseBlockStatement()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseRepeatStatement() *RepeatStatement {
+	stmt := &RepeatStatement{Token: p.curToken}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if !p.expectPeek(UNTIL) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	return stmt
+}
+
+func (p *Parser) parseGuardStatement() *GuardStatement {
+	stmt := &GuardStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(ELSE) {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	stmt.Else = p.parseBlockStatement()
+
 	return stmt
 }
 
@@ -384,6 +586,16 @@ func (p *Parser) parseForStatement() *ForStatement {
 
 	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// Two-variable form: for i, x in list / for k, v in map
+	if p.peekTokenIs(COMMA) {
+		p.nextToken()
+		if !p.expectPeek(IDENT) {
+			return nil
+		}
+		stmt.Index = stmt.Variable
+		stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
 	if !p.expectPeek(IN) {
 		return nil
 	}
@@ -400,6 +612,22 @@ func (p *Parser) parseForStatement() *ForStatement {
 	return stmt
 }
 
+func (p *Parser) parseTypeAliasStatement() *TypeAliasStatement {
+	stmt := &TypeAliasStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Value = p.parseTypeAnnotation()
+
+	return stmt
+}
+
 func (p *Parser) parseStructStatement() *StructStatement {
 	stmt := &StructStatement{Token: p.curToken}
 
@@ -477,6 +705,114 @@ func (p *Parser) parseExtendStatement() *ExtendStatement {
 	return stmt
 }
 
+func (p *Parser) parseEnumStatement() *EnumStatement {
+	stmt := &EnumStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
+		if !p.curTokenIs(IDENT) {
+			p.addError(p.curToken, "expected enum variant name, got %s", p.curToken.Type.String())
+			return nil
+		}
+		variant := &EnumVariant{
+			Name: &Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+
+		// Optional payload types: Circle(Float)
+		if p.peekTokenIs(LPAREN) {
+			p.nextToken()
+			for !p.peekTokenIs(RPAREN) {
+				p.nextToken()
+				variant.Fields = append(variant.Fields, p.parseTypeAnnotation())
+				if !p.peekTokenIs(COMMA) {
+					break
+				}
+				p.nextToken()
+			}
+			if !p.expectPeek(RPAREN) {
+				return nil
+			}
+		}
+
+		stmt.Variants = append(stmt.Variants, variant)
+
+		p.nextToken()
+		if p.curTokenIs(COMMA) || p.curTokenIs(NEWLINE) {
+			p.nextToken()
+		}
+		p.skipNewlines()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseInterfaceStatement() *InterfaceStatement {
+	stmt := &InterfaceStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
+		if p.curTokenIs(FUN) {
+			method := p.parseMethodSignature()
+			if method != nil {
+				stmt.Methods = append(stmt.Methods, method)
+			}
+		}
+		p.nextToken()
+		p.skipNewlines()
+	}
+
+	return stmt
+}
+
+// parseMethodSignature parses an interface method: a function without a body
+func (p *Parser) parseMethodSignature() *FunctionStatement {
+	stmt := &FunctionStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	stmt.Parameters = p.parseFunctionParameters()
+
+	if p.peekTokenIs(ARROW) {
+		p.nextToken() // consume '->'
+		p.nextToken() // move to type
+		stmt.ReturnType = p.parseTypeAnnotation()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseImportStatement() *ImportStatement {
 	stmt := &ImportStatement{Token: p.curToken}
 
@@ -502,8 +838,7 @@ func (p *Parser) parseExpressionStatement() *ExpressionStatement {
 func (p *Parser) parseExpression(precedence int) Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.errors = append(p.errors, fmt.Sprintf("line %d: no prefix parse function for %s found",
-			p.curToken.Line, p.curToken.Type.String()))
+		p.addError(p.curToken, "no prefix parse function for %s found", p.curToken.Type.String())
 		return nil
 	}
 
@@ -555,14 +890,20 @@ func (p *Parser) parseStructLiteralBody(name *Identifier) Expression {
 	p.skipNewlines()
 
 	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
-		fieldName := p.curToken.Literal
+		if p.curTokenIs(ELLIPSIS) {
+			// Spread: copy the fields of another instance
+			p.nextToken()
+			lit.Spreads = append(lit.Spreads, p.parseExpression(LOWEST))
+		} else {
+			fieldName := p.curToken.Literal
 
-		if !p.expectPeek(COLON) {
-			return nil
-		}
+			if !p.expectPeek(COLON) {
+				return nil
+			}
 
-		p.nextToken()
-		lit.Fields[fieldName] = p.parseExpression(LOWEST)
+			p.nextToken()
+			lit.Fields[fieldName] = p.parseExpression(LOWEST)
+		}
 
 		p.nextToken()
 		if p.curTokenIs(COMMA) || p.curTokenIs(NEWLINE) {
@@ -579,8 +920,12 @@ func (p *Parser) parseIntegerLiteral() Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("line %d: could not parse %q as integer",
-			p.curToken.Line, p.curToken.Literal))
+		// Literals too large for an Integer are BigInts
+		if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = n
+			return lit
+		}
+		p.addError(p.curToken, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -593,8 +938,7 @@ func (p *Parser) parseFloatLiteral() Expression {
 
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.errors = append(p.errors, fmt.Sprintf("line %d: could not parse %q as float",
-			p.curToken.Line, p.curToken.Literal))
+		p.addError(p.curToken, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -639,8 +983,7 @@ func (p *Parser) parseInfixExpression(left Expression) Expression {
 func (p *Parser) parseAssignmentExpression(left Expression) Expression {
 	ident, ok := left.(*Identifier)
 	if !ok {
-		p.errors = append(p.errors, fmt.Sprintf("line %d: left side of == must be an identifier",
-			p.curToken.Line))
+		p.addError(p.curToken, "left side of == must be an identifier, got %s", left.String())
 		return nil
 	}
 
@@ -655,11 +998,30 @@ func (p *Parser) parseAssignmentExpression(left Expression) Expression {
 	return expression
 }
 
+// parseGroupedExpression handles ( which is either a grouped expression
+// or, when a comma follows the first element, a tuple literal
 func (p *Parser) parseGroupedExpression() Expression {
+	token := p.curToken
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(COMMA) {
+		tuple := &TupleLiteral{Token: token, Elements: []Expression{exp}}
+		for p.peekTokenIs(COMMA) {
+			p.nextToken()
+			if p.peekTokenIs(RPAREN) {
+				break
+			}
+			p.nextToken()
+			tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+		}
+		if !p.expectPeek(RPAREN) {
+			return nil
+		}
+		return tuple
+	}
+
 	if !p.expectPeek(RPAREN) {
 		return nil
 	}
@@ -676,6 +1038,9 @@ func (p *Parser) parseListLiteral() Expression {
 func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	list := []Expression{}
 
+	// Newlines are insignificant inside brackets and parentheses
+	p.skipPeekNewlines()
+
 	if p.peekTokenIs(end) {
 		p.nextToken()
 		return list
@@ -683,11 +1048,18 @@ func (p *Parser) parseExpressionList(end TokenType) []Expression {
 
 	p.nextToken()
 	list = append(list, p.parseExpression(LOWEST))
+	p.skipPeekNewlines()
 
 	for p.peekTokenIs(COMMA) {
 		p.nextToken()
+		p.skipPeekNewlines()
+		// Allow a trailing comma before the closing token
+		if p.peekTokenIs(end) {
+			break
+		}
 		p.nextToken()
 		list = append(list, p.parseExpression(LOWEST))
+		p.skipPeekNewlines()
 	}
 
 	if !p.expectPeek(end) {
@@ -710,7 +1082,7 @@ func (p *Parser) parseBraceExpression() Expression {
 
 	// Empty map/block
 	if p.curTokenIs(RBRACE) {
-		return &MapLiteral{Token: token, Pairs: make(map[Expression]Expression)}
+		return &MapLiteral{Token: token}
 	}
 
 	// Check for lambda: identifier followed by ->
@@ -776,10 +1148,16 @@ func (p *Parser) parseLambdaMultiParam(token Token) Expression {
 }
 
 func (p *Parser) parseMapLiteralBody(token Token) Expression {
-	ml := &MapLiteral{Token: token, Pairs: make(map[Expression]Expression)}
+	ml := &MapLiteral{Token: token}
 
 	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
-		key := p.parseExpression(LOWEST)
+		var key Expression
+		if p.curTokenIs(IDENT) && p.peekTokenIs(COLON) {
+			// Shorthand: {name: x} is the same as {"name": x}
+			key = &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		} else {
+			key = p.parseExpression(LOWEST)
+		}
 
 		if !p.expectPeek(COLON) {
 			return nil
@@ -788,7 +1166,7 @@ func (p *Parser) parseMapLiteralBody(token Token) Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		ml.Pairs[key] = value
+		ml.Pairs = append(ml.Pairs, &MapPair{Key: key, Value: value})
 
 		p.nextToken()
 		if p.curTokenIs(COMMA) || p.curTokenIs(NEWLINE) {
@@ -840,6 +1218,7 @@ func (p *Parser) parseBlockStatement() *BlockStatement {
 		p.nextToken()
 		p.skipNewlines()
 	}
+	block.End = p.curToken
 
 	return block
 }
@@ -851,7 +1230,7 @@ func (p *Parser) parseCallExpression(function Expression) Expression {
 }
 
 func (p *Parser) parseMemberExpression(object Expression) Expression {
-	exp := &MemberExpression{Token: p.curToken, Object: object}
+	exp := &MemberExpression{Token: p.curToken, Object: object, Optional: p.curTokenIs(QUESTION_DOT)}
 
 	if !p.expectPeek(IDENT) {
 		return nil
@@ -860,7 +1239,7 @@ func (p *Parser) parseMemberExpression(object Expression) Expression {
 	exp.Member = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	// Check for .with { ... } syntax
-	if exp.Member.Value == "with" && p.peekTokenIs(LBRACE) {
+	if exp.Member.Value == "with" && !exp.Optional && p.peekTokenIs(LBRACE) {
 		return p.parseWithExpression(object)
 	}
 
@@ -978,44 +1357,76 @@ func (p *Parser) parseMatchExpression() Expression {
 func (p *Parser) parseMatchCase() *MatchCase {
 	mc := &MatchCase{}
 
-	// Parse pattern: Some(x), None, Ok(x), Error(x)
+	// Parse pattern: Some(x), None, Ok(x), Error(x), or literals like 1, 2, 3
 	mc.Pattern = p.parseExpression(LOWEST)
-
-	// Extract binding variable from pattern
-	switch pat := mc.Pattern.(type) {
-	case *OptionExpression:
-		if pat.IsSome {
-			if ident, ok := pat.Value.(*Identifier); ok {
-				mc.BindingVar = ident
-			}
-		}
-	case *ResultExpression:
-		if ident, ok := pat.Value.(*Identifier); ok {
-			mc.BindingVar = ident
-		}
+	for p.peekTokenIs(COMMA) {
+		p.nextToken()
+		p.nextToken()
+		mc.Alternatives = append(mc.Alternatives, p.parseExpression(LOWEST))
 	}
 
+	mc.BindingVar = patternBinding(mc.Pattern)
+
 	if !p.expectPeek(ARROW) {
 		return nil
 	}
 
-	if !p.expectPeek(LBRACE) {
+	if !p.peekTokenIs(LBRACE) {
 		// Single expression form
 		p.nextToken()
+		tok := p.curToken
 		expr := p.parseExpression(LOWEST)
 		mc.Body = &BlockStatement{
+			Token: tok,
 			Statements: []Statement{
-				&ExpressionStatement{Expression: expr},
+				&ExpressionStatement{Token: tok, Expression: expr},
 			},
+			End: tok,
 		}
 		return mc
 	}
 
+	p.nextToken()
 	mc.Body = p.parseBlockStatement()
 
 	return mc
 }
 
+// patternBinding extracts the binding variable from a Some(x), Ok(x) or Error(x) pattern
+func patternBinding(pattern Expression) *Identifier {
+	switch pat := pattern.(type) {
+	case *OptionExpression:
+		if pat.IsSome {
+			if ident, ok := pat.Value.(*Identifier); ok {
+				return ident
+			}
+		}
+	case *ResultExpression:
+		if ident, ok := pat.Value.(*Identifier); ok {
+			return ident
+		}
+	}
+	return nil
+}
+
+// splitEnumPattern splits a Shape.Circle(r) or Color.Red pattern into the
+// enum name, the variant name and the payload patterns
+func splitEnumPattern(pattern Expression) (enum, variant string, payload []Expression, ok bool) {
+	if call, isCall := pattern.(*CallExpression); isCall {
+		pattern = call.Function
+		payload = call.Arguments
+	}
+	member, isMember := pattern.(*MemberExpression)
+	if !isMember {
+		return "", "", nil, false
+	}
+	ident, isIdent := member.Object.(*Identifier)
+	if !isIdent {
+		return "", "", nil, false
+	}
+	return ident.Value, member.Member.Value, payload, true
+}
+
 func (p *Parser) parseMutableExpression() Expression {
 	exp := &MutableExpression{Token: p.curToken}
 
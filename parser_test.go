@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// walkNodes calls fn for every AST node reachable from v
+func walkNodes(v reflect.Value, fn func(Node)) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if n, ok := v.Interface().(Node); ok && v.Kind() == reflect.Pointer {
+			fn(n)
+		}
+		walkNodes(v.Elem(), fn)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walkNodes(v.Field(i), fn)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkNodes(v.Index(i), fn)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			walkNodes(v.MapIndex(key), fn)
+		}
+	}
+}
+
+func TestEveryNodeHasPosition(t *testing.T) {
+	source := `import time
+struct Point {
+    x: Integer
+}
+enum Shape {
+    Circle(Float)
+    Empty
+}
+interface Named {
+    fun name() -> String
+}
+extend Point {
+    fun sum() -> Integer {
+        return this.x
+    }
+}
+type Points = List[Point]
+def p = Point { x: 1 }
+def q = p.with { x: 2 }
+def (a, b) = (1, 2.5)
+def m = Mutable({"k": [1, 2]})
+fun f[T](v: T, n: Integer) -> Option[T] {
+    guard n > 0 else {
+        return None
+    }
+    return Some(v)
+}
+for i in range(0, 3) {
+    if i is 1 { continue } else { break }
+}
+outer: while false {
+    repeat {
+        break outer
+    } until true
+}
+def r: Result[Integer, String] = Ok(-a)
+def s = match r {
+    Ok(v) -> v * 2
+    Error(e) -> { 0 }
+}
+def g = { x -> x + p.x }
+m["k"]
+s == 3
+`
+	parser := NewParser(NewLexer(source))
+	program := parser.ParseProgram()
+	if errs := parser.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %q", errs)
+	}
+	walkNodes(reflect.ValueOf(program.Statements), func(n Node) {
+		if _, ok := nodeToken(n); !ok {
+			t.Errorf("%T %q has no position", n, n.String())
+		}
+	})
+}
+
+func TestMatchArmBodyPosition(t *testing.T) {
+	parser := NewParser(NewLexer("match 1 {\n    1 -> \"one\"\n    _ -> \"other\"\n}"))
+	program := parser.ParseProgram()
+	match := program.Statements[0].(*ExpressionStatement).Expression.(*MatchExpression)
+	for i, arm := range match.Cases {
+		tok, ok := nodeToken(arm.Body)
+		if !ok || tok.Line != i+2 || arm.Body.TokenLiteral() != arm.Body.Statements[0].TokenLiteral() {
+			t.Errorf("arm %d body at line %d (%q)", i, tok.Line, arm.Body.TokenLiteral())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// builtinModules are modules implemented in Go. Importing one of these names
+// never touches the disk, so they work even when file imports are disabled.
+var builtinModules = map[string]map[string]HostFunction{
+	"time":  timeModule,
+	"flags": flagsModule,
+}
+
+// newBuiltinModule creates a fresh module value for a built-in module
+func newBuiltinModule(name string, members map[string]HostFunction) *ModuleValue {
+	env := NewEnvironment()
+	for member, host := range members {
+		env.Set(member, &BuiltinFunction{Name: name + "." + member, Fn: host.Fn})
+	}
+	return &ModuleValue{Name: name, Exports: env}
+}
+
+// builtinModuleType describes a built-in module to the type checker as a
+// struct whose fields are its functions
+func builtinModuleType(name string, members map[string]HostFunction) *StructType {
+	fields := make(map[string]Type, len(members))
+	for member, host := range members {
+		if host.Type != nil {
+			fields[member] = host.Type
+		} else {
+			fields[member] = &FunctionType{Parameters: []Type{&AnyType{}}, Return: &AnyType{}}
+		}
+	}
+	fields["exports"] = &FunctionType{Parameters: []Type{}, Return: &ListType{Element: &StringType{}}}
+	return &StructType{Name: name, Fields: fields}
+}
+
+// time module
+
+var timeModule = map[string]HostFunction{
+	"now": {
+		Fn:   timeNow,
+		Type: &FunctionType{Parameters: []Type{}, Return: &IntegerType{}},
+	},
+	"format": {
+		Fn:   timeFormat,
+		Type: &FunctionType{Parameters: []Type{&IntegerType{}, &StringType{}}, Return: &StringType{}},
+	},
+	"sleep": {
+		Fn:   timeSleep,
+		Type: &FunctionType{Parameters: []Type{&IntegerType{}}, Return: &NullType{}},
+	},
+}
+
+func timeNow(args ...Value) Value {
+	if len(args) != 0 {
+		return &ErrorValue{Message: "time.now() takes no arguments"}
+	}
+	return &IntegerValue{Value: time.Now().Unix()}
+}
+
+func timeFormat(args ...Value) Value {
+	if len(args) != 2 {
+		return &ErrorValue{Message: "time.format() requires 2 arguments"}
+	}
+	ts, ok := UnwrapValue(args[0]).(*IntegerValue)
+	if !ok {
+		return &ErrorValue{Message: "time.format() timestamp must be an integer"}
+	}
+	layout, ok := UnwrapValue(args[1]).(*StringValue)
+	if !ok {
+		return &ErrorValue{Message: "time.format() layout must be a string"}
+	}
+	formatted, err := strftime(time.Unix(ts.Value, 0).UTC(), layout.Value)
+	if err != nil {
+		return &ErrorValue{Method: "time.format", Input: layout.Value, Message: err.Error()}
+	}
+	return &StringValue{Value: formatted}
+}
+
+func timeSleep(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "time.sleep() requires 1 argument"}
+	}
+	ms, ok := UnwrapValue(args[0]).(*IntegerValue)
+	if !ok || ms.Value < 0 {
+		return &ErrorValue{Message: "time.sleep() argument must be a non-negative integer"}
+	}
+	time.Sleep(time.Duration(ms.Value) * time.Millisecond)
+	return &NullValue{}
+}
+
+// strftimeLayouts maps strftime directives to Go layout fragments
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'H': "15", 'I': "03",
+	'M': "04", 'S': "05", 'p': "PM", 'b': "Jan", 'B': "January",
+	'a': "Mon", 'A': "Monday", 'j': "002", 'Z': "MST", 'z': "-0700",
+}
+
+// strftime formats t using strftime-style directives such as %Y-%m-%d.
+func strftime(t time.Time, layout string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			out.WriteByte(layout[i])
+			continue
+		}
+		if i+1 >= len(layout) {
+			return "", fmt.Errorf("layout ends with a lone %%")
+		}
+		i++
+		if layout[i] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		goLayout, ok := strftimeLayouts[layout[i]]
+		if !ok {
+			return "", fmt.Errorf("unknown directive %%%c", layout[i])
+		}
+		out.WriteString(t.Format(goLayout))
+	}
+	return out.String(), nil
+}
+
+// flags module
+
+var flagsModule = map[string]HostFunction{
+	"parse": {
+		Fn: flagsParse,
+		Type: &FunctionType{
+			Parameters: []Type{&ListType{Element: &StringType{}}},
+			Return: &TupleType{Elements: []Type{
+				&MapType{Key: &StringType{}, Value: &AnyType{}},
+				&ListType{Element: &StringType{}},
+			}},
+		},
+	},
+}
+
+// flagsParse splits command-line style arguments into a map of flags and a
+// list of positionals. "--key value" and "--key=value" give String values, a
+// "--key" not followed by a value is true, and everything after "--" is
+// positional.
+func flagsParse(args ...Value) Value {
+	if len(args) != 1 {
+		return &ErrorValue{Message: "flags.parse() requires 1 argument"}
+	}
+	list, ok := UnwrapValue(args[0]).(*ListValue)
+	if !ok {
+		return &ErrorValue{Message: "flags.parse() argument must be a list of strings"}
+	}
+	words := make([]string, len(list.Elements))
+	for i, elem := range list.Elements {
+		s, ok := UnwrapValue(elem).(*StringValue)
+		if !ok {
+			return &ErrorValue{Method: "flags.parse", Input: elem.String(),
+				Message: fmt.Sprintf("argument %d must be a string, got %s", i, elem.Type())}
+		}
+		words[i] = s.Value
+	}
+
+	named := make(map[string]Value)
+	positionals := []Value{}
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "--" {
+			for _, rest := range words[i+1:] {
+				positionals = append(positionals, &StringValue{Value: rest})
+			}
+			break
+		}
+		if !strings.HasPrefix(word, "--") || len(word) == 2 {
+			positionals = append(positionals, &StringValue{Value: word})
+			continue
+		}
+
+		name := word[2:]
+		if key, value, found := strings.Cut(name, "="); found {
+			named[key] = &StringValue{Value: value}
+		} else if i+1 < len(words) && !strings.HasPrefix(words[i+1], "--") {
+			named[name] = &StringValue{Value: words[i+1]}
+			i++
+		} else {
+			named[name] = &BooleanValue{Value: true}
+		}
+	}
+
+	return &TupleValue{Elements: []Value{
+		&MapValue{Pairs: named},
+		&ListValue{Elements: positionals},
+	}}
+}
@@ -20,10 +20,16 @@ const (
 	FUN
 	STRUCT
 	EXTEND
+	INTERFACE
+	ENUM
+	TYPE
 	IF
 	ELSE
 	WHILE
 	FOR
+	REPEAT
+	UNTIL
+	GUARD
 	IN
 	RETURN
 	MATCH
@@ -34,6 +40,7 @@ const (
 	IMPORT
 	AND
 	OR
+	XOR
 	NOT
 	IS
 	BREAK
@@ -55,6 +62,7 @@ const (
 	GTE        // >=
 	LTE        // <=
 	ARROW      // ->
+	PIPE       // |
 
 	// Delimiters
 	LPAREN   // (
@@ -66,6 +74,10 @@ const (
 	COMMA    // ,
 	COLON    // :
 	DOT      // .
+	ELLIPSIS // ...
+
+	QUESTION_DOT // ?.
+	COALESCE     // ??
 )
 
 var tokenNames = map[TokenType]string{
@@ -80,10 +92,16 @@ var tokenNames = map[TokenType]string{
 	FUN:        "FUN",
 	STRUCT:     "STRUCT",
 	EXTEND:     "EXTEND",
+	INTERFACE:  "INTERFACE",
+	ENUM:       "ENUM",
+	TYPE:       "TYPE",
 	IF:         "IF",
 	ELSE:       "ELSE",
 	WHILE:      "WHILE",
 	FOR:        "FOR",
+	REPEAT:     "REPEAT",
+	UNTIL:      "UNTIL",
+	GUARD:      "GUARD",
 	IN:         "IN",
 	RETURN:     "RETURN",
 	MATCH:      "MATCH",
@@ -94,6 +112,7 @@ var tokenNames = map[TokenType]string{
 	IMPORT:     "IMPORT",
 	AND:        "AND",
 	OR:         "OR",
+	XOR:        "XOR",
 	NOT:        "NOT",
 	IS:         "IS",
 	BREAK:      "BREAK",
@@ -113,6 +132,7 @@ var tokenNames = map[TokenType]string{
 	GTE:        ">=",
 	LTE:        "<=",
 	ARROW:      "->",
+	PIPE:       "|",
 	LPAREN:     "(",
 	RPAREN:     ")",
 	LBRACE:     "{",
@@ -122,6 +142,10 @@ var tokenNames = map[TokenType]string{
 	COMMA:      ",",
 	COLON:      ":",
 	DOT:        ".",
+	ELLIPSIS:   "...",
+
+	QUESTION_DOT: "?.",
+	COALESCE:     "??",
 }
 
 func (t TokenType) String() string {
@@ -141,31 +165,38 @@ type Token struct {
 
 // Keywords maps keyword strings to token types
 var keywords = map[string]TokenType{
-	"def":      DEF,
-	"fun":      FUN,
-	"struct":   STRUCT,
-	"extend":   EXTEND,
-	"if":       IF,
-	"else":     ELSE,
-	"while":    WHILE,
-	"for":      FOR,
-	"in":       IN,
-	"return":   RETURN,
-	"match":    MATCH,
-	"Some":     SOME,
-	"None":     NONE,
-	"Ok":       OK,
-	"Error":    ERROR,
-	"import":   IMPORT,
-	"and":      AND,
-	"or":       OR,
-	"not":      NOT,
-	"is":       IS,
-	"break":    BREAK,
-	"continue": CONTINUE,
-	"Mutable":  MUTABLE,
-	"true":     TRUE,
-	"false":    FALSE,
+	"def":       DEF,
+	"fun":       FUN,
+	"struct":    STRUCT,
+	"extend":    EXTEND,
+	"interface": INTERFACE,
+	"enum":      ENUM,
+	"type":      TYPE,
+	"if":        IF,
+	"else":      ELSE,
+	"while":     WHILE,
+	"for":       FOR,
+	"repeat":    REPEAT,
+	"until":     UNTIL,
+	"guard":     GUARD,
+	"in":        IN,
+	"return":    RETURN,
+	"match":     MATCH,
+	"Some":      SOME,
+	"None":      NONE,
+	"Ok":        OK,
+	"Error":     ERROR,
+	"import":    IMPORT,
+	"and":       AND,
+	"or":        OR,
+	"xor":       XOR,
+	"not":       NOT,
+	"is":        IS,
+	"break":     BREAK,
+	"continue":  CONTINUE,
+	"Mutable":   MUTABLE,
+	"true":      TRUE,
+	"false":     FALSE,
 }
 
 // LookupIdent checks if an identifier is a keyword
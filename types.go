@@ -1,5 +1,7 @@
 package main
 
+import "strings"
+
 // Type represents a type in the type system
 type Type interface {
 	typeNode()
@@ -17,6 +19,26 @@ func (t *IntegerType) Equals(o Type) bool {
 	return ok
 }
 
+// BigIntType represents the arbitrary-precision BigInt type
+type BigIntType struct{}
+
+func (t *BigIntType) typeNode()        {}
+func (t *BigIntType) String() string   { return "BigInt" }
+func (t *BigIntType) Equals(o Type) bool {
+	_, ok := o.(*BigIntType)
+	return ok
+}
+
+// DecimalType represents the exact base-10 Decimal type
+type DecimalType struct{}
+
+func (t *DecimalType) typeNode()        {}
+func (t *DecimalType) String() string   { return "Decimal" }
+func (t *DecimalType) Equals(o Type) bool {
+	_, ok := o.(*DecimalType)
+	return ok
+}
+
 // FloatType represents the Float type
 type FloatType struct{}
 
@@ -47,6 +69,16 @@ func (t *BooleanType) Equals(o Type) bool {
 	return ok
 }
 
+// BytesType represents a sequence of raw bytes
+type BytesType struct{}
+
+func (t *BytesType) typeNode()        {}
+func (t *BytesType) String() string   { return "Bytes" }
+func (t *BytesType) Equals(o Type) bool {
+	_, ok := o.(*BytesType)
+	return ok
+}
+
 // NullType represents the absence of a value
 type NullType struct{}
 
@@ -71,6 +103,37 @@ func (t *ListType) Equals(o Type) bool {
 	return false
 }
 
+// TupleType represents Tuple[A, B, ...]
+type TupleType struct {
+	Elements []Type
+}
+
+func (t *TupleType) typeNode() {}
+func (t *TupleType) String() string {
+	elements := ""
+	for i, e := range t.Elements {
+		if i > 0 {
+			elements += ", "
+		}
+		elements += e.String()
+	}
+	return "Tuple[" + elements + "]"
+}
+func (t *TupleType) Equals(o Type) bool {
+	if ot, ok := o.(*TupleType); ok {
+		if len(t.Elements) != len(ot.Elements) {
+			return false
+		}
+		for i, e := range t.Elements {
+			if !e.Equals(ot.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // MapType represents Map[K, V]
 type MapType struct {
 	Key   Type
@@ -178,6 +241,92 @@ func (t *StructType) Equals(o Type) bool {
 	return false
 }
 
+// InterfaceType is satisfied by any struct whose extension methods include
+// all of Methods
+type InterfaceType struct {
+	Name    string
+	Methods map[string]*FunctionType
+}
+
+func (t *InterfaceType) typeNode()      {}
+func (t *InterfaceType) String() string { return t.Name }
+func (t *InterfaceType) Equals(o Type) bool {
+	if ot, ok := o.(*InterfaceType); ok {
+		return t.Name == ot.Name
+	}
+	return false
+}
+
+// EnumType represents a user-defined sum type
+type EnumType struct {
+	Name     string
+	Variants []string          // in declaration order
+	Payloads map[string][]Type // variant name -> payload types
+}
+
+func (t *EnumType) typeNode()      {}
+func (t *EnumType) String() string { return t.Name }
+func (t *EnumType) Equals(o Type) bool {
+	if ot, ok := o.(*EnumType); ok {
+		return t.Name == ot.Name
+	}
+	return false
+}
+
+// UnionType represents a value of any one of several types: Integer | String
+type UnionType struct {
+	Members []Type
+}
+
+func (t *UnionType) typeNode() {}
+func (t *UnionType) String() string {
+	members := make([]string, len(t.Members))
+	for i, m := range t.Members {
+		members[i] = m.String()
+	}
+	return strings.Join(members, " | ")
+}
+func (t *UnionType) Equals(o Type) bool {
+	ot, ok := o.(*UnionType)
+	if !ok {
+		return false
+	}
+	return t.includesAll(ot) && ot.includesAll(t)
+}
+
+// Has reports whether one of the members equals m
+func (t *UnionType) Has(m Type) bool {
+	for _, member := range t.Members {
+		if member.Equals(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *UnionType) includesAll(o *UnionType) bool {
+	for _, m := range o.Members {
+		if !t.Has(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// TypeVariable represents a generic type parameter, like T in fun identity[T](x: T) -> T
+type TypeVariable struct {
+	Name string
+}
+
+func (t *TypeVariable) typeNode()      {}
+func (t *TypeVariable) String() string { return t.Name }
+func (t *TypeVariable) Equals(o Type) bool {
+	if ot, ok := o.(*TypeVariable); ok {
+		return t.Name == ot.Name
+	}
+	return false
+}
+
 // AnyType is a placeholder for unresolved types
 type AnyType struct{}
 
@@ -191,20 +340,40 @@ func TypeFromAnnotation(ta *TypeAnnotation) Type {
 		return &AnyType{}
 	}
 
+	if len(ta.Members) > 0 {
+		members := make([]Type, len(ta.Members))
+		for i, m := range ta.Members {
+			members[i] = TypeFromAnnotation(m)
+		}
+		return &UnionType{Members: members}
+	}
+
 	switch ta.Name {
 	case "Integer":
 		return &IntegerType{}
+	case "BigInt":
+		return &BigIntType{}
+	case "Decimal":
+		return &DecimalType{}
 	case "Float":
 		return &FloatType{}
 	case "String":
 		return &StringType{}
 	case "Boolean":
 		return &BooleanType{}
+	case "Bytes":
+		return &BytesType{}
 	case "List":
 		if len(ta.TypeParams) > 0 {
 			return &ListType{Element: TypeFromAnnotation(ta.TypeParams[0])}
 		}
 		return &ListType{Element: &AnyType{}}
+	case "Tuple":
+		elements := make([]Type, len(ta.TypeParams))
+		for i, p := range ta.TypeParams {
+			elements[i] = TypeFromAnnotation(p)
+		}
+		return &TupleType{Elements: elements}
 	case "Map":
 		keyType := &StringType{} // Default key type
 		valueType := Type(&AnyType{})
@@ -238,7 +407,134 @@ func TypeFromAnnotation(ta *TypeAnnotation) Type {
 			return &MutableType{Element: TypeFromAnnotation(ta.TypeParams[0])}
 		}
 		return &MutableType{Element: &AnyType{}}
+	case "Function":
+		// Any callable; calls through it are checked at runtime
+		return &AnyType{}
 	default:
 		return &StructType{Name: ta.Name, Fields: make(map[string]Type)}
 	}
 }
+
+// transformType rebuilds t bottom-up, replacing each type it contains with f(type)
+func transformType(t Type, f func(Type) Type) Type {
+	switch tt := t.(type) {
+	case *ListType:
+		return f(&ListType{Element: transformType(tt.Element, f)})
+	case *TupleType:
+		elements := make([]Type, len(tt.Elements))
+		for i, elem := range tt.Elements {
+			elements[i] = transformType(elem, f)
+		}
+		return f(&TupleType{Elements: elements})
+	case *MapType:
+		return f(&MapType{Key: transformType(tt.Key, f), Value: transformType(tt.Value, f)})
+	case *OptionType:
+		return f(&OptionType{Element: transformType(tt.Element, f)})
+	case *ResultType:
+		return f(&ResultType{ValueType: transformType(tt.ValueType, f), ErrorType: transformType(tt.ErrorType, f)})
+	case *MutableType:
+		return f(&MutableType{Element: transformType(tt.Element, f)})
+	case *FunctionType:
+		params := make([]Type, len(tt.Parameters))
+		for i, p := range tt.Parameters {
+			params[i] = transformType(p, f)
+		}
+		return f(&FunctionType{Parameters: params, Return: transformType(tt.Return, f)})
+	case *UnionType:
+		members := make([]Type, len(tt.Members))
+		for i, m := range tt.Members {
+			members[i] = transformType(m, f)
+		}
+		return f(&UnionType{Members: members})
+	}
+	return f(t)
+}
+
+// bindTypeParams turns annotations naming a type parameter (parsed as
+// struct types) into type variables
+func bindTypeParams(t Type, params []*Identifier) Type {
+	if len(params) == 0 {
+		return t
+	}
+	return transformType(t, func(t Type) Type {
+		if st, ok := t.(*StructType); ok {
+			for _, p := range params {
+				if p.Value == st.Name {
+					return &TypeVariable{Name: st.Name}
+				}
+			}
+		}
+		return t
+	})
+}
+
+// substituteTypeVariables replaces type variables with their bindings,
+// or Any when a variable was never bound
+func substituteTypeVariables(t Type, bindings map[string]Type) Type {
+	return transformType(t, func(t Type) Type {
+		if tv, ok := t.(*TypeVariable); ok {
+			if bound, ok := bindings[tv.Name]; ok {
+				return bound
+			}
+			return &AnyType{}
+		}
+		return t
+	})
+}
+
+// unifyTypes matches a parameter type against an argument type, recording
+// what each type variable stands for. It returns the name of a type
+// variable bound to two different types, or "" when they agree.
+func unifyTypes(param, arg Type, bindings map[string]Type) string {
+	if mut, ok := arg.(*MutableType); ok {
+		if _, ok := param.(*MutableType); !ok {
+			arg = mut.Element
+		}
+	}
+	if _, ok := arg.(*AnyType); ok {
+		return ""
+	}
+
+	switch p := param.(type) {
+	case *TypeVariable:
+		if bound, ok := bindings[p.Name]; ok {
+			if _, isAny := bound.(*AnyType); !isAny && !bound.Equals(arg) {
+				return p.Name
+			}
+			return ""
+		}
+		bindings[p.Name] = arg
+	case *ListType:
+		if a, ok := arg.(*ListType); ok {
+			return unifyTypes(p.Element, a.Element, bindings)
+		}
+	case *OptionType:
+		if a, ok := arg.(*OptionType); ok {
+			return unifyTypes(p.Element, a.Element, bindings)
+		}
+	case *MutableType:
+		if a, ok := arg.(*MutableType); ok {
+			return unifyTypes(p.Element, a.Element, bindings)
+		}
+	case *MapType:
+		if a, ok := arg.(*MapType); ok {
+			return unifyTypes(p.Value, a.Value, bindings)
+		}
+	case *ResultType:
+		if a, ok := arg.(*ResultType); ok {
+			if name := unifyTypes(p.ValueType, a.ValueType, bindings); name != "" {
+				return name
+			}
+			return unifyTypes(p.ErrorType, a.ErrorType, bindings)
+		}
+	case *TupleType:
+		if a, ok := arg.(*TupleType); ok && len(a.Elements) == len(p.Elements) {
+			for i := range p.Elements {
+				if name := unifyTypes(p.Elements[i], a.Elements[i], bindings); name != "" {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"sort"
 	"strings"
 )
@@ -20,6 +21,48 @@ type IntegerValue struct {
 func (iv *IntegerValue) Type() string   { return "Integer" }
 func (iv *IntegerValue) String() string { return fmt.Sprintf("%d", iv.Value) }
 
+// BigIntValue represents an arbitrary-precision integer. Operations always
+// make a new big.Int, so a Value is never changed once created.
+type BigIntValue struct {
+	Value *big.Int
+}
+
+func (bv *BigIntValue) Type() string   { return "BigInt" }
+func (bv *BigIntValue) String() string { return bv.Value.String() }
+
+// DecimalValue represents an exact base-10 number, kept as a fraction so
+// that sums like 0.1 + 0.2 have no rounding error. Like BigIntValue, it is
+// never changed once created.
+type DecimalValue struct {
+	Value *big.Rat
+}
+
+func (dv *DecimalValue) Type() string   { return "Decimal" }
+func (dv *DecimalValue) String() string { return formatDecimal(dv.Value) }
+
+// formatDecimal writes r with as many decimal places as it needs. A fraction
+// with no finite decimal form, such as 1/3 from division, is shown to 16
+// places, though the value itself stays exact.
+func formatDecimal(r *big.Rat) string {
+	// A fraction ends after n places when its denominator divides 10^n,
+	// that is when it has no prime factors but 2 and 5
+	denom := new(big.Int).Set(r.Denom())
+	places := 0
+	for _, p := range []int64{2, 5} {
+		factor := big.NewInt(p)
+		count := 0
+		for new(big.Int).Rem(denom, factor).Sign() == 0 {
+			denom.Quo(denom, factor)
+			count++
+		}
+		places = max(places, count)
+	}
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		return strings.TrimRight(r.FloatString(16), "0")
+	}
+	return r.FloatString(places)
+}
+
 // FloatValue represents a float
 type FloatValue struct {
 	Value float64
@@ -49,6 +92,14 @@ func (bv *BooleanValue) String() string {
 	return "false"
 }
 
+// BytesValue represents a sequence of raw bytes
+type BytesValue struct {
+	Value []byte
+}
+
+func (bv *BytesValue) Type() string   { return "Bytes" }
+func (bv *BytesValue) String() string { return fmt.Sprintf("Bytes(%x)", bv.Value) }
+
 // NullValue represents the absence of a value
 type NullValue struct{}
 
@@ -77,6 +128,20 @@ func (lv *ListValue) Append(v Value) *ListValue {
 	return &ListValue{Elements: newElements}
 }
 
+// TupleValue represents a fixed-size, heterogeneous tuple
+type TupleValue struct {
+	Elements []Value
+}
+
+func (tv *TupleValue) Type() string { return "Tuple" }
+func (tv *TupleValue) String() string {
+	var elements []string
+	for _, e := range tv.Elements {
+		elements = append(elements, e.String())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
 // MapValue represents a map
 type MapValue struct {
 	Pairs map[string]Value
@@ -119,11 +184,38 @@ func (fv *FunctionValue) String() string {
 type BuiltinFunction struct {
 	Name string
 	Fn   func(args ...Value) Value
+
+	// Call replaces Fn for builtins that need to call back into MoonShot
+	// functions, such as fill
+	Call func(eval *Evaluator, env *Environment, args ...Value) Value
 }
 
 func (bf *BuiltinFunction) Type() string   { return "Builtin" }
 func (bf *BuiltinFunction) String() string { return fmt.Sprintf("<builtin %s>", bf.Name) }
 
+// MemoizedFunction wraps a function, caching its results by argument values
+type MemoizedFunction struct {
+	Fn    Value
+	Cache map[string]Value
+}
+
+func (mf *MemoizedFunction) Type() string   { return "Function" }
+func (mf *MemoizedFunction) String() string { return fmt.Sprintf("<memoized %s>", mf.Fn.String()) }
+
+// ComposedFunction applies its functions right to left: compose(f, g)(x) is f(g(x))
+type ComposedFunction struct {
+	Functions []Value
+}
+
+func (cf *ComposedFunction) Type() string { return "Function" }
+func (cf *ComposedFunction) String() string {
+	var names []string
+	for _, fn := range cf.Functions {
+		names = append(names, fn.String())
+	}
+	return fmt.Sprintf("<composed %s>", strings.Join(names, ", "))
+}
+
 // StructDefinition represents a struct type definition
 type StructDefinition struct {
 	Name   string
@@ -133,6 +225,16 @@ type StructDefinition struct {
 func (sd *StructDefinition) Type() string   { return "StructDef" }
 func (sd *StructDefinition) String() string { return fmt.Sprintf("<struct %s>", sd.Name) }
 
+// fieldType returns the declared type of the named field, nil if unknown
+func (sd *StructDefinition) fieldType(name string) *TypeAnnotation {
+	for _, field := range sd.Fields {
+		if field.Name.Value == name {
+			return field.TypeHint
+		}
+	}
+	return nil
+}
+
 // StructValue represents an instance of a struct
 type StructValue struct {
 	Definition *StructDefinition
@@ -166,6 +268,28 @@ func (sv *StructValue) With(updates map[string]Value) *StructValue {
 	return &StructValue{Definition: sv.Definition, Fields: newFields}
 }
 
+// WithPath creates a new struct with the field at path replaced, rebuilding
+// each struct along the way. path holds field names, outermost first.
+func (sv *StructValue) WithPath(path []string, value Value) (*StructValue, error) {
+	if len(path) == 1 {
+		return sv.With(map[string]Value{path[0]: value}), nil
+	}
+
+	field, ok := sv.Fields[path[0]]
+	if !ok {
+		return nil, fmt.Errorf("undefined field %s on %s", path[0], sv.Type())
+	}
+	nested, ok := UnwrapValue(field).(*StructValue)
+	if !ok {
+		return nil, fmt.Errorf("field %s of %s is not a struct", path[0], sv.Type())
+	}
+	updated, err := nested.WithPath(path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return sv.With(map[string]Value{path[0]: updated}), nil
+}
+
 // OptionValue represents Some(x) or None
 type OptionValue struct {
 	IsSome bool
@@ -215,6 +339,7 @@ type ErrorValue struct {
 	Method  string
 	Input   string
 	Message string
+	Line    int // line of the statement that produced the error, 0 if unknown
 }
 
 func (ev *ErrorValue) Type() string { return "Error" }
@@ -234,17 +359,59 @@ func (rv *ReturnValue) Type() string   { return "Return" }
 func (rv *ReturnValue) String() string { return rv.Value.String() }
 
 // BreakValue signals a break from a loop
-type BreakValue struct{}
+type BreakValue struct {
+	Label string // target loop label, empty for the innermost loop
+}
 
 func (bv *BreakValue) Type() string   { return "Break" }
 func (bv *BreakValue) String() string { return "break" }
 
 // ContinueValue signals a continue in a loop
-type ContinueValue struct{}
+type ContinueValue struct {
+	Label string // target loop label, empty for the innermost loop
+}
 
 func (cv *ContinueValue) Type() string   { return "Continue" }
 func (cv *ContinueValue) String() string { return "continue" }
 
+// ExitValue signals that the program called exit(code). It unwinds
+// evaluation like an error and is turned into the process exit status by main.
+type ExitValue struct {
+	Code int
+}
+
+func (ev *ExitValue) Type() string   { return "Exit" }
+func (ev *ExitValue) String() string { return fmt.Sprintf("exit(%d)", ev.Code) }
+
+// EnumDefinition represents an enum type definition. Its variants are
+// reached as members: Color.Red, Shape.Circle(1.0)
+type EnumDefinition struct {
+	Name     string
+	Variants map[string]int // variant name -> number of payload values
+}
+
+func (ed *EnumDefinition) Type() string   { return "EnumDef" }
+func (ed *EnumDefinition) String() string { return fmt.Sprintf("<enum %s>", ed.Name) }
+
+// EnumValue is one variant of an enum, with its payload values if any
+type EnumValue struct {
+	Enum    string
+	Variant string
+	Values  []Value
+}
+
+func (ev *EnumValue) Type() string { return ev.Enum }
+func (ev *EnumValue) String() string {
+	if len(ev.Values) == 0 {
+		return ev.Variant
+	}
+	values := make([]string, len(ev.Values))
+	for i, v := range ev.Values {
+		values[i] = v.String()
+	}
+	return ev.Variant + "(" + strings.Join(values, ", ") + ")"
+}
+
 // ModuleValue represents an imported module
 type ModuleValue struct {
 	Name    string
@@ -262,7 +429,8 @@ func UnwrapValue(v Value) Value {
 	return v
 }
 
-// IsTruthy returns whether a value is truthy
+// IsTruthy returns whether a value is truthy. Zero numbers, empty strings,
+// bytes, lists and maps, None and Error are falsy; structs are always truthy.
 func IsTruthy(v Value) bool {
 	switch val := v.(type) {
 	case *BooleanValue:
@@ -271,12 +439,24 @@ func IsTruthy(v Value) bool {
 		return false
 	case *IntegerValue:
 		return val.Value != 0
+	case *BigIntValue:
+		return val.Value.Sign() != 0
+	case *DecimalValue:
+		return val.Value.Sign() != 0
+	case *FloatValue:
+		return val.Value != 0
 	case *StringValue:
 		return val.Value != ""
+	case *BytesValue:
+		return len(val.Value) > 0
 	case *ListValue:
 		return len(val.Elements) > 0
+	case *MapValue:
+		return len(val.Pairs) > 0
 	case *OptionValue:
 		return val.IsSome
+	case *ResultValue:
+		return val.IsOk
 	case *MutableValue:
 		return IsTruthy(val.Value)
 	default: